@@ -5,13 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/auth"
 	"github.com/ll2l/esweb/bookmarks"
 	"github.com/ll2l/esweb/client"
 	"github.com/ll2l/esweb/ui"
 	"io/ioutil"
+	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,6 +28,12 @@ const (
 
 var EsClient *client.Client
 
+// Migrations in progress, keyed by source and destination
+var migrations = struct {
+	sync.Mutex
+	running map[string]*client.MigrateConfig
+}{running: map[string]*client.MigrateConfig{}}
+
 // Send successful response back to client
 func respondSuccess(c *gin.Context, data interface{}) {
 	c.JSON(200, data)
@@ -45,7 +55,7 @@ func respondError(c *gin.Context, err interface{}) {
 }
 
 func IndexApi(c *gin.Context) {
-	c.HTML(200, "index.html", gin.H{})
+	c.HTML(200, "index.html", gin.H{"Prefix": PathPrefix})
 	return
 }
 
@@ -67,43 +77,176 @@ func serveStaticAsset(path string, c *gin.Context) {
 }
 
 func Connect(c *gin.Context) {
-	host := c.PostForm("host")
-	user := c.PostForm("user")
-	alias := c.PostForm("alias")
-	password := c.PostForm("password")
+	bk, err := parseConnectForm(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	// Bookmarks are listed without their secrets, a bookmark picked in the connection
+	// form is sent by name and its stored secrets are used for the same cluster
+	bookmarked := false
+	var stored bookmarks.Bookmark
+	if name := c.PostForm("bookmark"); name != "" {
+		if conf, err := visibleBookmark(c, name); err == nil && sameCluster(conf, bk) {
+			bookmarked = true
+			stored = conf
+			conf, err = conf.Resolved()
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			if bk.Password == "" && conf.User == bk.User {
+				bk.Password = conf.Password
+			}
+			if bk.APIKey == "" {
+				bk.APIKey = conf.APIKey
+			}
+			if bk.BearerToken == "" {
+				bk.BearerToken = conf.BearerToken
+			}
+			if bk.ClientKey == "" && bk.ClientCert == conf.ClientCert {
+				bk.ClientKey = conf.ClientKey
+			}
+		}
+	}
+
+	// Users without the connect permission are limited to their bookmarks
+	if !bookmarked && !allowed(c, auth.PermConnect) {
+		return
+	}
+
+	if err := checkInlinePEM(bk, stored); err != nil {
+		badRequest(c, err)
+		return
+	}
 
-	cl, err := client.NewFromParams(host, alias, user, password)
+	if err := bk.Validate(); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	// Protected indices of a bookmarked cluster stay protected however it is connected to
+	bk.Protected = protectedPatterns(bk)
+
+	// The connection is used by the users who may see its bookmark, or who have the
+	// roles of the user who connected
+	if bookmarked {
+		bk.Roles = stored.Roles
+	} else if u := currentUser(c); u != nil {
+		bk.Roles = u.Roles
+	}
+
+	cl, err := client.NewFromConfig(bk)
 	if err != nil {
 		respondError(c, err)
 		return
 	}
 
-	if !cl.Alive() {
-		respondError(c, fmt.Sprintf("connect %s failed", host))
+	if err := cl.Ping(); err != nil {
+		respondError(c, fmt.Errorf("connect %s failed: %s", strings.Join(bk.Addresses, ", ")+bk.CloudID, err))
 		return
 	}
 	EsClient = cl
 	GetConnectionInfo(c)
 
-	// add to clusters
+	// Keep it for switching clusters until the server restarts, without replacing a saved bookmark
+	if old, err := bookmarks.GetClusterConfig(bk.Alias); err != nil || old.Transient {
+		bk.Transient = true
+		// It holds the secrets of this user, only users with the same roles may switch to it
+		if u := currentUser(c); u != nil {
+			bk.Roles = u.Roles
+		}
+		bookmarks.Set(bk.Alias, bk)
+	}
+}
+
+// parseConnectForm reads the connection options of the connect form, they cannot be
+// secret references
+func parseConnectForm(c *gin.Context) (bookmarks.Bookmark, error) {
+	password := c.PostForm("password")
+	if password == "" {
+		password = c.PostForm("pass")
+	}
+	// The connect form sends the password URL encoded
+	password, _ = url.QueryUnescape(password)
+
 	bk := bookmarks.Bookmark{
-		Addresses: []string{host},
-		Alias:     alias,
+		Addresses:   splitList(c.PostForm("host")),
+		User:        c.PostForm("user"),
+		Password:    password,
+		Alias:       c.PostForm("alias"),
+		Kibana:      c.PostForm("kibana_url"),
+		APIKey:      c.PostForm("api_key"),
+		BearerToken: c.PostForm("bearer_token"),
+		CloudID:     strings.TrimSpace(c.PostForm("cloud_id")),
+		CACert:      c.PostForm("ca_cert"),
+		ClientCert:  c.PostForm("client_cert"),
+		ClientKey:   c.PostForm("client_key"),
+		Insecure:    c.PostForm("insecure") == "true",
 	}
+	return bk, bk.CheckNoReferences()
+}
 
-	if user != "" {
-		bk.User = user
+// checkInlinePEM rejects certificates and keys sent over HTTP as file paths, the server
+// only reads the files named in the bookmark file. Values equal to those of the stored
+// bookmark the connection is made with are its own.
+func checkInlinePEM(bk, stored bookmarks.Bookmark) error {
+	options := [][3]string{
+		{"ca_cert", bk.CACert, stored.CACert},
+		{"client_cert", bk.ClientCert, stored.ClientCert},
+		{"client_key", bk.ClientKey, stored.ClientKey},
+	}
+	for _, o := range options {
+		if o[1] != "" && o[1] != o[2] && !client.IsInlinePEM(o[1]) {
+			return fmt.Errorf("%s must be PEM data, file paths are only read from the bookmark file", o[0])
+		}
 	}
+	return nil
+}
 
-	if password != "" {
-		bk.Password = password
+// protectedPatterns returns the protected index patterns of the bookmarks of the cluster,
+// a bookmark sharing one of its addresses included
+func protectedPatterns(bk bookmarks.Bookmark) []string {
+	seen := map[string]bool{}
+	patterns := []string{}
+	for _, b := range bookmarks.List() {
+		if !sameCluster(b, bk) && !sharesAddress(b, bk) {
+			continue
+		}
+		for _, p := range b.Protected {
+			if !seen[p] {
+				seen[p] = true
+				patterns = append(patterns, p)
+			}
+		}
 	}
+	sort.Strings(patterns)
+	return patterns
+}
 
-	bookmarks.Clusters[alias] = bk
+// sharesAddress reports whether two connections have an address in common
+func sharesAddress(a, b bookmarks.Bookmark) bool {
+	normalize := func(addr string) string {
+		return strings.TrimRight(strings.ToLower(strings.TrimSpace(addr)), "/")
+	}
+	for _, x := range a.Addresses {
+		for _, y := range b.Addresses {
+			if normalize(x) == normalize(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameCluster reports whether two connections point to the same cluster
+func sameCluster(a, b bookmarks.Bookmark) bool {
+	return a.CloudID == b.CloudID && strings.Join(a.Addresses, ",") == strings.Join(b.Addresses, ",")
 }
 
 func GetConnectionInfo(c *gin.Context) {
-	res, err := EsClient.Info()
+	res, err := esClient(c).Info()
 	if err != nil {
 		respondError(c, err)
 		return
@@ -122,20 +265,25 @@ func SwitchCluster(c *gin.Context) {
 		return
 	}
 
-	conf, err := bookmarks.GetClusterConfig(name)
+	conf, err := visibleBookmark(c, name)
 	if err != nil {
 		respondError(c, err)
 		return
 	}
 
-	cl, err := client.NewFromBookmarks(conf)
+	// Transient bookmarks are kept from the connect form, they hold no references
+	connect := client.NewFromBookmarks
+	if conf.Transient {
+		connect = client.NewFromConfig
+	}
+	cl, err := connect(conf)
 	if err != nil {
 		respondError(c, err)
 		return
 	}
 
-	if !cl.Alive() {
-		respondError(c, fmt.Errorf("cluster %s is not alive", name))
+	if err := cl.Ping(); err != nil {
+		respondError(c, fmt.Errorf("cluster %s is not alive: %s", name, err))
 		return
 	}
 
@@ -145,13 +293,13 @@ func SwitchCluster(c *gin.Context) {
 
 func GetObjects(c *gin.Context) {
 
-	custerName, err := EsClient.ClusterName()
+	custerName, err := esClient(c).ClusterName()
 	if err != nil {
 		respondError(c, err)
 		return
 	}
 
-	indices, err := EsClient.Indices()
+	indices, err := esClient(c).Indices()
 	if err != nil {
 		respondError(c, err)
 		return
@@ -163,7 +311,7 @@ func GetObjects(c *gin.Context) {
 		},
 	}
 
-	aliases, err := EsClient.Aliases()
+	aliases, err := esClient(c).Aliases()
 	if err != nil {
 		respondSuccess(c, resp)
 		return
@@ -174,7 +322,14 @@ func GetObjects(c *gin.Context) {
 }
 
 func GetClusters(c *gin.Context) {
-	respondSuccess(c, bookmarks.GetBookmarks())
+	u := currentUser(c)
+	names := []string{}
+	for _, name := range bookmarks.GetBookmarks() {
+		if conf, err := bookmarks.GetClusterConfig(name); err == nil && u.CanSee(conf.Roles) {
+			names = append(names, name)
+		}
+	}
+	respondSuccess(c, names)
 }
 
 func GetKibana(c *gin.Context) {
@@ -183,13 +338,193 @@ func GetKibana(c *gin.Context) {
 	respondSuccess(c, gin.H{"kibana": kibanaUrl})
 }
 
+// GetBookmarks lists the bookmarks, passwords are never included
 func GetBookmarks(c *gin.Context) {
-	respondSuccess(c, bookmarks.Clusters)
+	u := currentUser(c)
+	list := bookmarks.List()
+	for name, b := range list {
+		if !u.CanSee(b.Roles) {
+			delete(list, name)
+		}
+	}
+	respondSuccess(c, list)
+}
+
+// parseBookmark reads a bookmark from the request form. Secret references are only
+// read from the bookmark file, the form cannot send them.
+func parseBookmark(c *gin.Context) (bookmarks.Bookmark, error) {
+	bk := bookmarks.Bookmark{
+		Addresses: splitList(strings.Replace(c.Request.FormValue("addresses"), "\n", ",", -1)),
+		User:      strings.TrimSpace(c.Request.FormValue("user")),
+		Password:  c.Request.FormValue("password"),
+		APIKey:    c.Request.FormValue("api_key"),
+		Alias:     strings.TrimSpace(c.Request.FormValue("alias")),
+		Kibana:    strings.TrimSpace(c.Request.FormValue("kibana")),
+
+		BearerToken: c.Request.FormValue("bearer_token"),
+		CloudID:     strings.TrimSpace(c.Request.FormValue("cloud_id")),
+		CACert:      c.Request.FormValue("ca_cert"),
+		ClientCert:  c.Request.FormValue("client_cert"),
+		ClientKey:   c.Request.FormValue("client_key"),
+		Insecure:    c.Request.FormValue("insecure") == "true",
+		Roles:       splitList(c.Request.FormValue("roles")),
+		Protected:   splitList(c.Request.FormValue("protected")),
+	}
+	return bk, bk.CheckNoReferences()
+}
+
+// CreateBookmark saves a new bookmark to the bookmark file
+func CreateBookmark(c *gin.Context) {
+	name, err := bookmarks.NormalizeName(c.Request.FormValue("name"))
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	bk, err := parseBookmark(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if bk.Alias == "" {
+		bk.Alias = name
+	}
+	if err := checkInlinePEM(bk, bookmarks.Bookmark{}); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	if err := bookmarks.Create(name, bk); err != nil {
+		respondError(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"name": name, "bookmark": bk.Public()})
+}
+
+// UpdateBookmark changes a bookmark, renaming it when new_name is given.
+// The password is kept unless a new one or clear_password is sent.
+func UpdateBookmark(c *gin.Context) {
+	name := c.Params.ByName("name")
+	old, err := bookmarks.GetClusterConfig(name)
+	if err != nil {
+		errorResponse(c, 404, err)
+		return
+	}
+
+	newName := name
+	if n := c.Request.FormValue("new_name"); n != "" {
+		var err error
+		if newName, err = bookmarks.NormalizeName(n); err != nil {
+			badRequest(c, err)
+			return
+		}
+	}
+
+	bk, err := parseBookmark(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if bk.Alias == "" {
+		bk.Alias = newName
+	}
+	if err := checkInlinePEM(bk, old); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	if err := bookmarks.Update(name, newName, bk, c.Request.FormValue("clear_password") == "true"); err != nil {
+		respondError(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"name": newName, "bookmark": bk.Public()})
+}
+
+// DeleteBookmark removes a bookmark from the bookmark file
+func DeleteBookmark(c *gin.Context) {
+	name := c.Params.ByName("name")
+	if _, err := bookmarks.GetClusterConfig(name); err != nil {
+		errorResponse(c, 404, err)
+		return
+	}
+
+	if err := bookmarks.Delete(name); err != nil {
+		respondError(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"name": name})
+}
+
+// TestBookmark checks a bookmark can connect, form values override the saved bookmark given by name
+func TestBookmark(c *gin.Context) {
+	bk, err := parseBookmark(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	var stored bookmarks.Bookmark
+	if name := c.Request.FormValue("name"); name != "" {
+		saved, err := visibleBookmark(c, name)
+		if err != nil {
+			errorResponse(c, 404, err)
+			return
+		}
+		if len(bk.Addresses) == 0 && bk.CloudID == "" {
+			bk.Addresses, bk.CloudID = saved.Addresses, saved.CloudID
+		}
+		// Stored secrets are only sent to the bookmarked cluster, and only their
+		// references are resolved
+		if sameCluster(saved, bk) {
+			stored = saved
+			if saved, err = saved.Resolved(); err != nil {
+				respondError(c, err)
+				return
+			}
+			if bk.User == "" {
+				bk.User = saved.User
+			}
+			if bk.Password == "" {
+				bk.Password = saved.Password
+			}
+			if bk.APIKey == "" {
+				bk.APIKey = saved.APIKey
+			}
+			if bk.BearerToken == "" {
+				bk.BearerToken = saved.BearerToken
+			}
+			if bk.ClientKey == "" {
+				bk.ClientKey = saved.ClientKey
+			}
+		}
+	}
+
+	if err := checkInlinePEM(bk, stored); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := bk.Validate(); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	cl, err := client.NewFromConfig(bk)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	info, err := cl.Info()
+	if err != nil {
+		respondError(c, fmt.Errorf("cannot connect to %s: %s", strings.Join(bk.Addresses, ", "), err))
+		return
+	}
+	respondSuccess(c, info)
 }
 
 func GetIndexInfo(c *gin.Context) {
 	indexName := c.Params.ByName("index")
-	res, err := EsClient.IndexInfo(indexName)
+	res, err := esClient(c).IndexInfo(indexName)
 	if err != nil {
 		respondError(c, err)
 		return
@@ -200,8 +535,27 @@ func GetIndexInfo(c *gin.Context) {
 func ManageIndex(c *gin.Context) {
 	index := c.Params.ByName("index")
 	action := c.PostForm("action")
+	setAuditAction(c, "index:"+action)
+	if !allowed(c, auth.PermIndex+action) {
+		return
+	}
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
 
-	err := EsClient.ManageIndex(index, action)
+	if client.IsDestructive(action) {
+		if err := cl.CheckProtected(index, action); err != nil {
+			errorResponse(c, 403, err)
+			return
+		}
+		if err := useConfirmation(c, index, action); err != nil {
+			badRequest(c, err)
+			return
+		}
+	}
+
+	err := cl.ManageIndex(index, action)
 	if err != nil {
 		respondError(c, err)
 		return
@@ -217,7 +571,7 @@ func ManageIndex(c *gin.Context) {
 
 func GetSettings(c *gin.Context) {
 	indexName := c.Params.ByName("index")
-	res, err := EsClient.Settings(indexName)
+	res, err := esClient(c).Settings(indexName)
 	if err != nil {
 		respondError(c, err)
 		return
@@ -227,7 +581,7 @@ func GetSettings(c *gin.Context) {
 
 func GetStats(c *gin.Context) {
 	indexName := c.Params.ByName("index")
-	res, err := EsClient.Stats(indexName)
+	res, err := esClient(c).Stats(indexName)
 	if err != nil {
 		respondError(c, err)
 		return
@@ -239,7 +593,7 @@ func GetStats(c *gin.Context) {
 }
 
 func GetTasks(c *gin.Context) {
-	res, err := EsClient.Tasks()
+	res, err := esClient(c).Tasks()
 	if err != nil {
 		respondError(c, err)
 		return
@@ -268,7 +622,7 @@ func GetTasks(c *gin.Context) {
 
 func GetMapping(c *gin.Context) {
 	indexName := c.Params.ByName("index")
-	res, err := EsClient.Mapping(indexName)
+	res, err := esClient(c).Mapping(indexName)
 	if err != nil {
 		respondError(c, err)
 		return
@@ -285,16 +639,14 @@ func GetMapping(c *gin.Context) {
 		resultTable.Columns = []string{"column_name", "data_type"}
 		resultTable.Rows = []client.Row{}
 		// app.js -> buildTableFilters
-		for _, properties := range res[indexName].(map[string]interface{})["mappings"].(map[string]interface{}) {
-			for k, v := range properties.(map[string]interface{})["properties"].(map[string]interface{}) {
-				ft, ok := v.(map[string]interface{})["type"]
-				if !ok {
-					ft = "object"
-				} else {
-					ft = ft.(string)
-				}
-				resultTable.Rows = append(resultTable.Rows, client.Row{k, ft})
+		for k, v := range client.MappingProperties(res[indexName].(map[string]interface{})["mappings"]) {
+			ft, ok := v.(map[string]interface{})["type"]
+			if !ok {
+				ft = "object"
+			} else {
+				ft = ft.(string)
 			}
+			resultTable.Rows = append(resultTable.Rows, client.Row{k, ft})
 		}
 		result = resultTable
 	}
@@ -302,7 +654,7 @@ func GetMapping(c *gin.Context) {
 }
 
 func GetInfo(c *gin.Context) {
-	res, err := EsClient.Info()
+	res, err := esClient(c).Info()
 	if err != nil {
 		respondError(c, err)
 		return
@@ -329,7 +681,7 @@ func GetIndexRows(c *gin.Context) {
 		Where:      c.Request.FormValue("where"),
 	}
 
-	res, err := EsClient.QueryRows(index, opts)
+	res, err := esClient(c).QueryRows(index, opts)
 	if err != nil {
 		respondError(c, err)
 		return
@@ -375,11 +727,12 @@ func RunQuery(c *gin.Context) {
 	}
 
 	if editor == "json" {
-		res, err := EsClient.SearchWithBody(index, query)
+		res, cache, err := esClient(c).SearchWithBody(index, query)
 		if err != nil {
 			respondError(c, err)
 			return
 		}
+		setCacheHeaders(c, cache)
 		respondSuccess(c, res)
 	} else {
 		HandleQuery(query, c)
@@ -392,12 +745,79 @@ func HandleQuery(query string, c *gin.Context) {
 		query = string(rawQuery)
 	}
 
-	res, err := EsClient.Query(query)
+	statements := client.SplitStatements(query)
+	if len(statements) > 1 {
+		runScript(c, statements)
+		return
+	}
+	if len(statements) == 1 {
+		query = statements[0]
+	}
+
+	res, engine, err := esClient(c).Query(query, c.Request.FormValue("engine"))
 	if err != nil {
 		badRequest(c, err)
 		return
 	}
+	c.Header("X-SQL-Engine", engine)
+	setCacheHeaders(c, cacheInfo(res))
+	serveSearchResult(c, res)
+}
+
+// statementResult is the outcome of one statement of a SQL script
+type statementResult struct {
+	Statement string       `json:"statement"`
+	Engine    string       `json:"engine,omitempty"`
+	Columns   []string     `json:"columns"`
+	Rows      []client.Row `json:"rows"`
+	Took      int64        `json:"took_ms"`
+	Cached    bool         `json:"cached,omitempty"`
+	CacheAge  int64        `json:"cache_age,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// runScript runs the statements of a script in order, a failed statement does not
+// stop the ones after it. Results are sent as a list, there is no file format for it.
+func runScript(c *gin.Context, statements []string) {
+	if getQueryParam(c, "format") != "" {
+		badRequest(c, "only a single statement can be exported")
+		return
+	}
+
+	engine := c.Request.FormValue("engine")
+	results := make([]statementResult, 0, len(statements))
+	for _, stmt := range statements {
+		r := statementResult{Statement: stmt, Columns: []string{}, Rows: []client.Row{}}
+
+		start := time.Now()
+		res, used, err := esClient(c).Query(stmt, engine)
+		r.Took = int64(time.Since(start) / time.Millisecond)
+		r.Engine = used
+
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			cache := cacheInfo(res)
+			r.Cached, r.CacheAge = cache.Hit, int64(cache.Age/time.Second)
+			if !res.IsEmpty() {
+				table := res.AsTableRows()
+				r.Columns, r.Rows = table.Columns, table.Rows
+			}
+		}
+		results = append(results, r)
+	}
 
+	respondSuccess(c, gin.H{"results": results})
+}
+
+// searchResult is a search response of the client
+type searchResult interface {
+	IsEmpty() bool
+	AsTableRows() *client.Table
+}
+
+// serveSearchResult sends search hits as a table, or as a file in the format query parameter
+func serveSearchResult(c *gin.Context, res searchResult) {
 	format := getQueryParam(c, "format")
 	filename := getQueryParam(c, "filename")
 
@@ -435,7 +855,7 @@ func DataExport(c *gin.Context) {
 	index := strings.TrimSpace(c.Request.FormValue("table"))
 
 	dumper := client.MigrateConfig{
-		SrcEs:        EsClient,
+		SrcEs:        esClient(c),
 		SrcIndexName: index,
 	}
 
@@ -447,63 +867,330 @@ func DataExport(c *gin.Context) {
 		fmt.Sprintf(`attachment; filename="%s.csv"`, cleanFilename),
 	)
 
-	err := dumper.Export(EsClient, c.Writer)
+	err := dumper.Export(dumper.SrcEs, c.Writer)
 	if err != nil {
 		badRequest(c, err)
 	}
 }
 
-func Migrate(c *gin.Context) {
+// Builds a migration from the form values shared by migrate and verify
+func parseMigrateConfig(c *gin.Context) (*client.MigrateConfig, error) {
+	if EsClient == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
 	srcIndex := strings.TrimSpace(c.Request.FormValue("src_index"))
 	dstHost := strings.TrimSpace(c.Request.FormValue("dst_host"))
 	dstIndex := strings.TrimSpace(c.Request.FormValue("dst_index"))
 	dstUser := c.Request.FormValue("dst_user")
 	dstPassword := c.Request.FormValue("dst_pass")
-	numItems := c.Request.FormValue("num_items")
 
 	if srcIndex == "" || dstIndex == "" {
-		respondError(c, "the index cannot be empty")
+		return nil, fmt.Errorf("the index cannot be empty")
+	}
+
+	if dstHost == "" {
+		return nil, fmt.Errorf("destination host cannot be empty")
+	}
+	dst := bookmarks.Bookmark{Addresses: []string{dstHost}, User: dstUser, Password: dstPassword}
+	if err := dst.CheckNoReferences(); err != nil {
+		return nil, err
+	}
+
+	transform, err := parseTransform(c)
+	if err != nil {
+		return nil, err
+	}
+
+	dumper := &client.MigrateConfig{
+		SrcEs:        EsClient,
+		SrcIndexName: srcIndex,
+		DstIndexName: dstIndex,
+		Transform:    transform,
+		Where:        strings.TrimSpace(c.Request.FormValue("where")),
+		Query:        strings.TrimSpace(c.Request.FormValue("query")),
+		Sort:         splitList(c.Request.FormValue("sort")),
+		DstMode:      c.Request.FormValue("dst_mode"),
+		DstMappings:  c.Request.FormValue("mappings"),
+		TypeField:    strings.TrimSpace(c.Request.FormValue("type_field")),
+		Confirm:      strings.TrimSpace(c.Request.FormValue("confirm")),
+	}
+
+	if _, err := dumper.SearchQuery(); err != nil {
+		return nil, err
+	}
+
+	if err := parseMigrateTuning(c, dumper); err != nil {
+		return nil, err
+	}
+
+	dumper.DstEs, err = client.NewFromParams(dstHost, "migrateDstHost", dstUser, dstPassword)
+	if err != nil {
+		return nil, err
+	}
+	dumper.DstEs.Protected = protectedPatterns(dst)
+
+	return dumper, nil
+}
+
+func Migrate(c *gin.Context) {
+	numItems := c.Request.FormValue("num_items")
+	verify := c.Request.FormValue("verify")
+
+	// Overwriting deletes the destination index
+	if c.Request.FormValue("dst_mode") == client.DstModeOverwrite && !allowed(c, auth.PermIndex+"delete") {
+		return
+	}
+
+	dumper, err := parseMigrateConfig(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	// Every document is migrated unless num_items limits it
+	if numItems != "" {
+		n, err := strconv.Atoi(numItems)
+		if err != nil {
+			badRequest(c, "num_items must be a number")
+			return
+		}
+		dumper.NumMigrations = int64(n)
+	}
+
+	if verify == "true" {
+		verify = client.VerifyFull
+	}
+	// A limited migration copies whichever documents the scroll slices return first
+	if verify != "" && dumper.NumMigrations > 0 {
+		badRequest(c, "a migration limited by num_items cannot be verified")
+		return
+	}
+
+	verifySize, err := parseIntFormValue(c, "verify_size", 1000)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if verifySize <= 0 {
+		badRequest(c, "verify_size must be greater than 0")
+		return
+	}
+
+	key := dumper.SrcIndexName + " -> " + c.Request.FormValue("dst_host") + "/" + dumper.DstIndexName
+	migrations.Lock()
+	if _, ok := migrations.running[key]; ok {
+		migrations.Unlock()
+		badRequest(c, fmt.Sprintf("migration %s is already running", key))
+		return
+	}
+	migrations.running[key] = dumper
+	migrations.Unlock()
+
+	defer func() {
+		migrations.Lock()
+		delete(migrations.running, key)
+		migrations.Unlock()
+	}()
+
+	err = dumper.Migrate()
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	resp := gin.H{"status": "success", "stats": dumper.Stats()}
+	if verify != "" {
+		report, err := dumper.Verify(verify, verifySize)
+		if err != nil {
+			resp["verify_error"] = err.Error()
+		} else {
+			resp["verify"] = report
+		}
+	}
+	respondSuccess(c, resp)
+}
+
+// VerifyMigration compares a migrated destination index with its source
+func VerifyMigration(c *gin.Context) {
+	dumper, err := parseMigrateConfig(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	size, err := parseIntFormValue(c, "verify_size", 1000)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if size <= 0 {
+		badRequest(c, "verify_size must be greater than 0")
+		return
+	}
+
+	mode := c.Request.FormValue("verify")
+	if mode == "" {
+		mode = client.VerifyFull
+	}
+
+	report, err := dumper.Verify(mode, size)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	respondSuccess(c, report)
+}
+
+// DownloadVerifyReport sends the problem documents of a verification as NDJSON
+func DownloadVerifyReport(c *gin.Context) {
+	name := c.Params.ByName("name")
+	path, err := client.VerifyReportPath(name)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	c.File(path)
+}
+
+// GetMigrateStatus reports progress and throughput of the running migrations
+func GetMigrateStatus(c *gin.Context) {
+	migrations.Lock()
+	defer migrations.Unlock()
+
+	stats := make(map[string]client.MigrateStats, len(migrations.running))
+	for key, mc := range migrations.running {
+		stats[key] = mc.Stats()
+	}
+	respondSuccess(c, stats)
+}
+
+// GetDeadLetters lists the dead-letter files of past migrations
+func GetDeadLetters(c *gin.Context) {
+	files, err := client.ListDeadLetterFiles()
+	if err != nil {
+		respondError(c, err)
 		return
 	}
+	respondSuccess(c, files)
+}
 
+// DownloadDeadLetter sends a dead-letter file as NDJSON
+func DownloadDeadLetter(c *gin.Context) {
+	name := c.Params.ByName("name")
+	path, err := client.DeadLetterPath(name)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	c.File(path)
+}
+
+// ReplayDeadLetter migrates the documents of a dead-letter file again
+func ReplayDeadLetter(c *gin.Context) {
+	dstHost := strings.TrimSpace(c.Request.FormValue("dst_host"))
 	if dstHost == "" {
 		respondError(c, "destination host cannot be empty")
 		return
 	}
 
-	numMigrations, err := strconv.Atoi(numItems)
+	path, err := client.DeadLetterPath(c.Request.FormValue("file"))
 	if err != nil {
-		numMigrations = 100
+		badRequest(c, err)
+		return
+	}
+
+	transform, err := parseTransform(c)
+	if err != nil {
+		badRequest(c, err)
+		return
 	}
 
 	dumper := client.MigrateConfig{
-		SrcEs:         EsClient,
-		SrcIndexName:  srcIndex,
-		DstIndexName:  dstIndex,
-		NumMigrations: int64(numMigrations),
+		DstIndexName: strings.TrimSpace(c.Request.FormValue("dst_index")),
+		Transform:    transform,
+		DstMode:      c.Request.FormValue("dst_mode"),
 	}
 
-	dumper.DstEs, err = client.NewFromParams(dstHost, "migrateDstHost", dstUser, dstPassword)
+	if err := parseMigrateTuning(c, &dumper); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	dst := bookmarks.Bookmark{Addresses: []string{dstHost}, User: c.Request.FormValue("dst_user"), Password: c.Request.FormValue("dst_pass")}
+	if err := dst.CheckNoReferences(); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	dumper.DstEs, err = client.NewFromParams(dstHost, "migrateDstHost", dst.User, dst.Password)
 	if err != nil {
 		badRequest(c, err)
 		return
 	}
 
-	err = dumper.Migrate()
+	if err := dumper.Replay(path); err != nil {
+		badRequest(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"status": "success", "stats": dumper.Stats()})
+}
+
+// MigratePreview shows a few source documents with the migration transform applied
+func MigratePreview(c *gin.Context) {
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	srcIndex := strings.TrimSpace(c.Request.FormValue("src_index"))
+	if srcIndex == "" {
+		respondError(c, "the index cannot be empty")
+		return
+	}
+
+	size, err := parseIntFormValue(c, "size", 5)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	transform, err := parseTransform(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	dumper := client.MigrateConfig{
+		SrcEs:        cl,
+		SrcIndexName: srcIndex,
+		Transform:    transform,
+		Where:        strings.TrimSpace(c.Request.FormValue("where")),
+		Query:        strings.TrimSpace(c.Request.FormValue("query")),
+		Sort:         splitList(c.Request.FormValue("sort")),
+	}
+
+	docs, err := dumper.Preview(size)
 	if err != nil {
 		badRequest(c, err)
 		return
 	}
-	respondSuccess(c, gin.H{"status": "success"})
+	respondSuccess(c, docs)
 }
 
 func GetHistory(c *gin.Context) {
 	respondSuccess(c, client.History[EsClient.Alias])
 }
 
+// GetDsl shows the search body of a SQL statement, the index it searches and the
+// engine which translated it
 func GetDsl(c *gin.Context) {
 	query := cleanQuery(c.Request.FormValue("query"))
-	dsl, _, err := EsClient.GetDsl(query)
+	dsl, index, engine, err := esClient(c).GetDsl(query, c.Request.FormValue("engine"))
 	if err != nil {
 		respondError(c, err)
 		return
@@ -512,7 +1199,8 @@ func GetDsl(c *gin.Context) {
 	var raw map[string]interface{}
 	if err := json.Unmarshal([]byte(dsl), &raw); err != nil {
 		respondError(c, fmt.Sprintf("unmarshal failed: %s", dsl))
+		return
 	}
 
-	respondSuccess(c, raw)
+	respondSuccess(c, gin.H{"engine": engine, "index": index, "dsl": raw})
 }
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/audit"
+)
+
+const auditActionKey = "audit_action"
+
+// errorRecorder keeps the body of error responses for the audit log
+type errorRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *errorRecorder) Write(b []byte) (int, error) {
+	if w.Status() >= 400 && w.body.Len() < 4096 {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// audited records the call in the audit log, targets are the route parameters
+// or form values naming the indices, hosts or bookmarks it changes
+func audited(action string, targets ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Parse the form before the handler, so the parameters can still be read afterwards
+		c.Request.ParseMultipartForm(32 << 20)
+
+		w := &errorRecorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		r := audit.Record{
+			ClientIP: c.ClientIP(),
+			Action:   action,
+			Params:   audit.Params(c.Request.Form),
+			Status:   w.Status(),
+			Outcome:  audit.OutcomeSuccess,
+		}
+		if a, ok := c.Get(auditActionKey); ok {
+			r.Action = a.(string)
+		}
+		r.User = userName(c)
+		if EsClient != nil {
+			r.Cluster = EsClient.Alias
+		}
+		for _, t := range targets {
+			val := c.Params.ByName(t)
+			if val == "" {
+				val = c.Request.FormValue(t)
+			}
+			r.Targets = append(r.Targets, splitList(val)...)
+		}
+
+		if r.Status >= 400 {
+			r.Outcome = audit.OutcomeFailure
+			var resp struct {
+				Error interface{} `json:"error"`
+			}
+			if json.Unmarshal(w.body.Bytes(), &resp) == nil && resp.Error != nil {
+				if msg, ok := resp.Error.(string); ok {
+					r.Error = msg
+				}
+			}
+		}
+
+		if err := audit.Write(r); err != nil {
+			log.Printf("cannot write audit log: %s", err)
+		}
+	}
+}
+
+// setAuditAction names the action more precisely than the route does
+func setAuditAction(c *gin.Context, action string) {
+	c.Set(auditActionKey, action)
+}
+
+// GetAuditLog returns the newest audit records, filtered by user, action and target
+func GetAuditLog(c *gin.Context) {
+	limit, err := parseIntFormValue(c, "limit", 100)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	records, err := audit.Read(audit.Filter{
+		User:   c.Request.FormValue("user"),
+		Action: c.Request.FormValue("action"),
+		Target: c.Request.FormValue("target"),
+		Limit:  limit,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondSuccess(c, records)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/audit"
+	"github.com/ll2l/esweb/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Audited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir, err := ioutil.TempDir("", "esweb-audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	audit.File = filepath.Join(dir, "audit.log")
+	defer func() { audit.File = "" }()
+
+	hash, _ := auth.HashPassword("pass")
+	assert.NoError(t, auth.AddUser(&auth.User{Name: "viewer", Password: hash, Roles: []string{auth.RoleViewer}}))
+	assert.NoError(t, auth.AddUser(&auth.User{Name: "admin", Password: hash, Roles: []string{auth.RoleAdmin}}))
+	defer auth.Reset()
+
+	r := gin.New()
+	r.Use(BasicAuth)
+	mountRoutes(r)
+
+	call := func(method, path, user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(user, "pass")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 403, call("PUT", "/api/indices/logs", "viewer", "action=delete").Code)
+	assert.Equal(t, 400, call("POST", "/api/bookmarks", "admin", "name=Bad Name&password=s3cret").Code)
+	assert.Equal(t, 403, call("GET", "/api/audit", "viewer", "").Code)
+
+	w := call("GET", "/api/audit", "admin", "")
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cret")
+
+	var records []audit.Record
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &records))
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "bookmark:create", records[0].Action)
+		assert.Equal(t, audit.OutcomeFailure, records[0].Outcome)
+		assert.Equal(t, audit.Redacted, records[0].Params["password"])
+		assert.NotEmpty(t, records[0].Error)
+
+		assert.Equal(t, "index:delete", records[1].Action)
+		assert.Equal(t, "viewer", records[1].User)
+		assert.Equal(t, []string{"logs"}, records[1].Targets)
+		assert.Equal(t, 403, records[1].Status)
+	}
+}
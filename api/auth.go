@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/auth"
+	"github.com/ll2l/esweb/bookmarks"
+)
+
+const userKey = "esweb_user"
+
+// BasicAuth asks for the HTTP basic credentials of a user in the users file
+func BasicAuth(c *gin.Context) {
+	if !auth.Enabled() {
+		return
+	}
+
+	if name, pass, ok := c.Request.BasicAuth(); ok {
+		if u, ok := auth.Authenticate(name, pass); ok {
+			c.Set(userKey, u)
+			return
+		}
+	}
+
+	c.Header("WWW-Authenticate", `Basic realm="esweb"`)
+	c.AbortWithStatus(401)
+}
+
+// currentUser returns the signed in user, nil when authentication is disabled
+func currentUser(c *gin.Context) *auth.User {
+	if u, ok := c.Get(userKey); ok {
+		return u.(*auth.User)
+	}
+	return nil
+}
+
+// authorize lets through users with the permission who may use the active connection
+func authorize(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !allowed(c, perm) || !connectionVisible(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// permit lets through users with the permission, whatever the active connection is.
+// It is for the routes which do not use the connection, such as the bookmarks and
+// switching clusters.
+func permit(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !allowed(c, perm) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// connectionVisible responds with 403 unless the user may see the bookmark of the active
+// connection, every user shares it
+func connectionVisible(c *gin.Context) bool {
+	if EsClient == nil || currentUser(c).CanSee(EsClient.Roles) {
+		return true
+	}
+	errorResponse(c, 403, fmt.Errorf("the active connection %s is not available to you, switch cluster", EsClient.Alias))
+	return false
+}
+
+// allowed responds with 403 unless the user has the permission
+func allowed(c *gin.Context, perm string) bool {
+	if currentUser(c).Can(perm) {
+		return true
+	}
+	errorResponse(c, 403, fmt.Errorf("permission denied, %s is required", perm))
+	return false
+}
+
+// visibleBookmark returns the bookmark name if the user may see it
+func visibleBookmark(c *gin.Context, name string) (bookmarks.Bookmark, error) {
+	conf, err := bookmarks.GetClusterConfig(name)
+	if err != nil || !currentUser(c).CanSee(conf.Roles) {
+		return bookmarks.Bookmark{}, fmt.Errorf("couldn't find a config with name %s", name)
+	}
+	return conf, nil
+}
+
+// GetMe returns the signed in user with its permissions
+func GetMe(c *gin.Context) {
+	u := currentUser(c)
+	if u == nil {
+		respondSuccess(c, gin.H{"name": "", "roles": []string{}, "permissions": u.Permissions()})
+		return
+	}
+	respondSuccess(c, gin.H{"name": u.Name, "roles": u.Roles, "permissions": u.Permissions()})
+}
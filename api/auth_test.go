@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/auth"
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/ll2l/esweb/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Authorize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash, _ := auth.HashPassword("pass")
+	assert.NoError(t, auth.AddUser(&auth.User{Name: "viewer", Password: hash, Roles: []string{auth.RoleViewer}}))
+	assert.NoError(t, auth.AddUser(&auth.User{Name: "operator", Password: hash, Roles: []string{auth.RoleOperator}}))
+	defer auth.Reset()
+
+	bookmarks.Set("public", bookmarks.Bookmark{Addresses: []string{"http://localhost:9200"}, Transient: true})
+	bookmarks.Set("ops", bookmarks.Bookmark{Addresses: []string{"http://localhost:9200"}, Roles: []string{auth.RoleOperator}, Transient: true})
+	defer bookmarks.Delete("public")
+	defer bookmarks.Delete("ops")
+
+	r := gin.New()
+	r.Use(BasicAuth)
+	mountRoutes(r)
+
+	call := func(method, path, user, pass string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader("action=delete"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 401, call("GET", "/api/bookmarks", "", "").Code)
+	assert.Equal(t, 401, call("GET", "/api/bookmarks", "viewer", "wrong").Code)
+
+	w := call("GET", "/api/bookmarks", "viewer", "pass")
+	assert.Equal(t, 200, w.Code)
+	var list map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Contains(t, list, "public")
+	assert.NotContains(t, list, "ops")
+
+	assert.Equal(t, 403, call("PUT", "/api/indices/logs", "viewer", "pass").Code)
+	assert.Equal(t, 403, call("DELETE", "/api/bookmarks/public", "viewer", "pass").Code)
+	assert.Equal(t, 403, call("POST", "/api/migrate", "viewer", "pass").Code)
+	assert.Equal(t, 403, call("POST", "/api/migrate?dst_mode=overwrite", "operator", "pass").Code)
+	assert.Equal(t, 403, call("DELETE", "/api/query-stats", "viewer", "pass").Code)
+	assert.Equal(t, 403, call("GET", "/api/query-stats?all=true", "viewer", "pass").Code)
+
+	w = call("GET", "/api/me", "viewer", "pass")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"permissions":["query","read"]`)
+}
+
+func Test_AuthorizeActiveConnection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash, _ := auth.HashPassword("pass")
+	assert.NoError(t, auth.AddUser(&auth.User{Name: "viewer", Password: hash, Roles: []string{auth.RoleViewer}}))
+	defer auth.Reset()
+
+	old := EsClient
+	defer func() { EsClient = old }()
+	EsClient = &client.Client{Alias: "ops", Roles: []string{auth.RoleOperator}}
+
+	r := gin.New()
+	r.Use(BasicAuth)
+	mountRoutes(r)
+
+	call := func(method, path string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.SetBasicAuth("viewer", "pass")
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// The connection an operator switched to is not used by a viewer
+	assert.Equal(t, 403, call("GET", "/api/history"))
+	assert.Equal(t, 403, call("GET", "/api/query?query=select"))
+	assert.Equal(t, 200, call("GET", "/api/bookmarks"))
+	assert.Equal(t, 200, call("GET", "/api/clusters"))
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/client"
+)
+
+// Suggestion is an autocomplete entry in the format of the ace editor completers
+type Suggestion struct {
+	Caption string `json:"caption"`
+	Value   string `json:"value"`
+	Meta    string `json:"meta"`
+	Score   int    `json:"score"`
+}
+
+// Scores order suggestions of the same prefix, higher first
+const (
+	scoreKeyword = 100
+	scoreIndex   = 300
+	scoreField   = 400
+	scoreClause  = 500
+)
+
+// GetAutocomplete returns the suggestions of the SQL editor (editor=sql) or of the JSON
+// editor (editor=json), for which path is a JSON array of the keys enclosing the cursor.
+// Fields are read from the mapping of index, only keywords are suggested when no cluster
+// is connected.
+func GetAutocomplete(c *gin.Context) {
+	index := strings.TrimSpace(c.Request.FormValue("index"))
+	suggestions := []Suggestion{}
+	cl := esClient(c)
+
+	var withFields bool
+	if c.Request.FormValue("editor") == "json" {
+		path := []string{}
+		if raw := c.Request.FormValue("path"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &path); err != nil {
+				badRequest(c, "path must be a JSON array of keys")
+				return
+			}
+		}
+
+		var keys []string
+		keys, withFields = client.DSLCompletions(path)
+		for _, k := range keys {
+			suggestions = append(suggestions, Suggestion{Caption: k, Value: k, Meta: "clause", Score: scoreClause})
+		}
+	} else {
+		withFields = true
+		for _, k := range client.SQLKeywords {
+			suggestions = append(suggestions, Suggestion{Caption: k, Value: k, Meta: "keyword", Score: scoreKeyword})
+		}
+		if cl != nil {
+			suggestions = append(suggestions, objectSuggestions(cl)...)
+		}
+	}
+
+	if withFields && index != "" && cl != nil {
+		fields, err := cl.FieldTypes(index)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			suggestions = append(suggestions, Suggestion{Caption: name, Value: name, Meta: fields[name], Score: scoreField})
+		}
+	}
+
+	respondSuccess(c, suggestions)
+}
+
+// objectSuggestions returns the index and alias names of the cluster
+func objectSuggestions(cl *client.Client) []Suggestion {
+	suggestions := []Suggestion{}
+	seen := map[string]bool{}
+	add := func(items []interface{}, key, meta string) {
+		for _, item := range items {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := m[key].(string)
+			if name == "" || seen[meta+name] {
+				continue
+			}
+			seen[meta+name] = true
+			suggestions = append(suggestions, Suggestion{Caption: name, Value: name, Meta: meta, Score: scoreIndex})
+		}
+	}
+
+	if indices, err := cl.Indices(); err == nil {
+		add(indices, "index", "index")
+	}
+	if aliases, err := cl.Aliases(); err == nil {
+		add(aliases, "alias", "alias")
+	}
+	return suggestions
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CertificatePathsRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	mountRoutes(r)
+
+	call := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	host := "https://127.0.0.1:1"
+	assert.Equal(t, 400, call("POST", "/api/connect", url.Values{"host": {host}, "ca_cert": {"/etc/passwd"}}).Code)
+	assert.Equal(t, 400, call("POST", "/api/bookmarks/test", url.Values{"addresses": {host}, "client_cert": {"/etc/passwd"}, "client_key": {"/etc/shadow"}}).Code)
+	assert.Equal(t, 400, call("POST", "/api/bookmarks", url.Values{"name": {"certs"}, "addresses": {host}, "ca_cert": {"/etc/passwd"}}).Code)
+}
+
+func Test_SecretReferencesRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	mountRoutes(r)
+
+	call := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	host := "http://127.0.0.1:1"
+	bookmarks.Set("refs", bookmarks.Bookmark{Addresses: []string{host}, Transient: true})
+	defer bookmarks.Delete("refs")
+
+	assert.Equal(t, 400, call("POST", "/api/bookmarks/test", url.Values{"addresses": {host}, "password": {"cmd:touch /tmp/esweb-test"}}).Code)
+	assert.Equal(t, 400, call("POST", "/api/bookmarks", url.Values{"name": {"refs"}, "addresses": {host}, "api_key": {"env:HOME"}}).Code)
+	assert.Equal(t, 400, call("PUT", "/api/bookmarks/refs", url.Values{"addresses": {host}, "bearer_token": {"file:/etc/passwd"}}).Code)
+	assert.Equal(t, 400, call("POST", "/api/connect", url.Values{"host": {host}, "password": {"file:/etc/passwd"}}).Code)
+	assert.Equal(t, 400, call("POST", "/api/migrate/replay", url.Values{"dst_host": {host}, "dst_pass": {"cmd:id"}, "file": {"x.ndjson"}}).Code)
+}
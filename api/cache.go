@@ -0,0 +1,36 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/client"
+)
+
+// cachedResult is a result which may come from the result cache
+type cachedResult interface {
+	Cache() client.CacheInfo
+}
+
+// cacheInfo returns the cache info of a query result, a miss for the results which
+// are never cached
+func cacheInfo(res interface{}) client.CacheInfo {
+	if r, ok := res.(cachedResult); ok {
+		return r.Cache()
+	}
+	return client.CacheInfo{}
+}
+
+// setCacheHeaders tells in X-Cache whether a result came from the result cache,
+// and its age in seconds in Age
+func setCacheHeaders(c *gin.Context, info client.CacheInfo) {
+	if client.CacheTTL <= 0 {
+		return
+	}
+	if !info.Hit {
+		c.Header("X-Cache", "MISS")
+		return
+	}
+	c.Header("X-Cache", "HIT")
+	c.Header("Age", strconv.Itoa(int(info.Age.Seconds())))
+}
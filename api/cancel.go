@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/client"
+)
+
+const (
+	queryIDKey = "query_id"
+	timeoutKey = "timeout"
+)
+
+// runningQuery is a request started with a query id, by the user who may cancel it
+type runningQuery struct {
+	user   string
+	cancel context.CancelFunc
+}
+
+var (
+	runningMu sync.Mutex
+	running   = map[string]*runningQuery{}
+)
+
+// parseTimeout reads a timeout given as a duration such as 30s or as seconds
+func parseTimeout(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", s)
+	}
+	return d, nil
+}
+
+// userQueryID scopes a query id chosen by the browser to the signed in user, it keys
+// the running queries and tags their Elasticsearch tasks so users only reach their own
+func userQueryID(c *gin.Context, id string) string {
+	if id == "" {
+		return ""
+	}
+	if user := userName(c); user != "" {
+		return user + ":" + id
+	}
+	return id
+}
+
+// bindRequest makes the request context cancellable by the query_id form value, and
+// reads the timeout of the searches of the request
+func bindRequest(c *gin.Context) {
+	timeout, err := parseTimeout(c.Request.FormValue(timeoutKey))
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	c.Set(timeoutKey, timeout)
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+
+	id := userQueryID(c, strings.TrimSpace(c.Request.FormValue(queryIDKey)))
+	if id != "" {
+		q := &runningQuery{user: userName(c), cancel: cancel}
+		runningMu.Lock()
+		running[id] = q
+		runningMu.Unlock()
+
+		defer func() {
+			runningMu.Lock()
+			if running[id] == q {
+				delete(running, id)
+			}
+			runningMu.Unlock()
+		}()
+	}
+
+	c.Next()
+}
+
+// esClient returns the connection for the calls of a request, they stop when the
+// client goes away or the query is cancelled. no_cache=true skips the result cache.
+func esClient(c *gin.Context) *client.Client {
+	if EsClient == nil {
+		return nil
+	}
+	timeout, _ := c.Get(timeoutKey)
+	d, _ := timeout.(time.Duration)
+
+	id := userQueryID(c, strings.TrimSpace(c.Request.FormValue(queryIDKey)))
+	cl := EsClient.WithContext(c.Request.Context(), id, d)
+	if c.Request.FormValue("no_cache") == "true" {
+		cl = cl.BypassCache()
+	}
+	return cl
+}
+
+// connectedClient returns the client of the request, or responds 400 and returns nil
+// when no cluster is connected
+func connectedClient(c *gin.Context) *client.Client {
+	cl := esClient(c)
+	if cl == nil {
+		badRequest(c, "not connected")
+	}
+	return cl
+}
+
+// CancelQuery stops a running query of the user by the query_id it was sent with, its
+// request ends and its search tasks are cancelled in Elasticsearch
+func CancelQuery(c *gin.Context) {
+	if EsClient == nil {
+		badRequest(c, "not connected")
+		return
+	}
+	id := c.Params.ByName("id")
+	key := userQueryID(c, id)
+
+	runningMu.Lock()
+	q := running[key]
+	if q != nil && q.user != userName(c) {
+		q = nil
+	}
+	runningMu.Unlock()
+	if q != nil {
+		q.cancel()
+	}
+
+	tasks, err := EsClient.CancelQuery(key)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if q == nil && tasks == 0 {
+		errorResponse(c, 404, fmt.Errorf("query %s is not running", id))
+		return
+	}
+	respondSuccess(c, gin.H{"id": id, "tasks": tasks})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/auth"
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/ll2l/esweb/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseTimeout(t *testing.T) {
+	d, err := parseTimeout("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), d)
+
+	d, err = parseTimeout("30")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, d)
+
+	d, err = parseTimeout(" 1m30s ")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	_, err = parseTimeout("soon")
+	assert.Error(t, err)
+	_, err = parseTimeout("-5s")
+	assert.Error(t, err)
+}
+
+func Test_CancelQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash, _ := auth.HashPassword("pass")
+	assert.NoError(t, auth.AddUser(&auth.User{Name: "alice", Password: hash, Roles: []string{auth.RoleViewer}}))
+	assert.NoError(t, auth.AddUser(&auth.User{Name: "bob", Password: hash, Roles: []string{auth.RoleViewer}}))
+	defer auth.Reset()
+
+	r := gin.New()
+	r.Use(BasicAuth)
+	mountRoutes(r)
+
+	call := func(user string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/cancel/q1", nil)
+		req.SetBasicAuth(user, "pass")
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	old := EsClient
+	defer func() { EsClient = old }()
+	EsClient = nil
+	assert.Equal(t, 400, call("alice"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.2"}}`))
+		default:
+			w.Write([]byte(`{"nodes":{}}`))
+		}
+	}))
+	defer srv.Close()
+	cl, err := client.NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+	EsClient = cl
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runningMu.Lock()
+	running["alice:q1"] = &runningQuery{user: "alice", cancel: cancel}
+	runningMu.Unlock()
+	defer func() {
+		runningMu.Lock()
+		delete(running, "alice:q1")
+		runningMu.Unlock()
+	}()
+
+	// Another user does not reach the query by its id
+	assert.Equal(t, 404, call("bob"))
+	assert.NoError(t, ctx.Err())
+
+	assert.Equal(t, 200, call("alice"))
+	assert.Error(t, ctx.Err())
+}
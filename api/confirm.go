@@ -0,0 +1,110 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/auth"
+	"github.com/ll2l/esweb/client"
+)
+
+// How long a confirmation token can be used
+const confirmTTL = 2 * time.Minute
+
+// confirmation is a one time permission for a destructive index action
+type confirmation struct {
+	user    string
+	cluster *client.Client
+	index   string
+	action  string
+	expires time.Time
+}
+
+var confirmations = struct {
+	sync.Mutex
+	tokens map[string]confirmation
+}{tokens: map[string]confirmation{}}
+
+// ConfirmIndexAction issues a token for a destructive index action, the index
+// name has to be typed again in confirm
+func ConfirmIndexAction(c *gin.Context) {
+	index := c.Params.ByName("index")
+	action := c.Request.FormValue("action")
+	if !allowed(c, auth.PermIndex+action) {
+		return
+	}
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	if !client.IsDestructive(action) {
+		badRequest(c, fmt.Sprintf("%s does not need a confirmation", action))
+		return
+	}
+	if c.Request.FormValue("confirm") != index {
+		badRequest(c, "the index name does not match, type it exactly to confirm")
+		return
+	}
+	if err := cl.CheckProtected(index, action); err != nil {
+		errorResponse(c, 403, err)
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		respondError(c, err)
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	confirmations.Lock()
+	now := time.Now()
+	for t, conf := range confirmations.tokens {
+		if now.After(conf.expires) {
+			delete(confirmations.tokens, t)
+		}
+	}
+	confirmations.tokens[token] = confirmation{
+		user:    userName(c),
+		cluster: EsClient,
+		index:   index,
+		action:  action,
+		expires: now.Add(confirmTTL),
+	}
+	confirmations.Unlock()
+
+	respondSuccess(c, gin.H{"token": token, "expires_in": int(confirmTTL.Seconds())})
+}
+
+// useConfirmation checks and spends the token of a destructive index action
+func useConfirmation(c *gin.Context, index, action string) error {
+	token := c.Request.FormValue("token")
+	if token == "" {
+		return fmt.Errorf("%s needs a confirmation token, type the index name to confirm", action)
+	}
+
+	confirmations.Lock()
+	conf, ok := confirmations.tokens[token]
+	delete(confirmations.tokens, token)
+	confirmations.Unlock()
+
+	if !ok || time.Now().After(conf.expires) {
+		return fmt.Errorf("the confirmation token is invalid or expired")
+	}
+	if conf.user != userName(c) || conf.cluster != EsClient || conf.index != index || conf.action != action {
+		return fmt.Errorf("the confirmation token was issued for %s of %s", conf.action, conf.index)
+	}
+	return nil
+}
+
+func userName(c *gin.Context) string {
+	if u := currentUser(c); u != nil {
+		return u.Name
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/ll2l/esweb/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ConfirmIndexAction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	old := EsClient
+	defer func() { EsClient = old }()
+	EsClient = nil
+
+	r := gin.New()
+	r.POST("/confirm/:index", ConfirmIndexAction)
+	r.PUT("/check/:index", func(c *gin.Context) {
+		if err := useConfirmation(c, c.Params.ByName("index"), c.PostForm("action")); err != nil {
+			badRequest(c, err)
+			return
+		}
+		respondSuccess(c, gin.H{})
+	})
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(w, req)
+		return w
+	}
+	token := func(w *httptest.ResponseRecorder) string {
+		s := w.Body.String()
+		i := strings.Index(s, `"token":"`)
+		if i < 0 {
+			return ""
+		}
+		return s[i+9 : i+9+32]
+	}
+
+	w := call("POST", "/confirm/logs", "action=delete&confirm=logs")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "not connected")
+
+	cl, err := client.NewFromConfig(bookmarks.Bookmark{Addresses: []string{"http://127.0.0.1:1"}, Protected: []string{"prod-*"}})
+	assert.NoError(t, err)
+	EsClient = cl
+
+	assert.Equal(t, 400, call("POST", "/confirm/logs", "action=delete&confirm=log").Code)
+	assert.Equal(t, 400, call("POST", "/confirm/logs", "action=refresh&confirm=logs").Code)
+	assert.Equal(t, 403, call("POST", "/confirm/prod-logs", "action=delete&confirm=prod-logs").Code)
+
+	assert.Equal(t, 400, call("PUT", "/check/logs", "action=delete").Code)
+
+	w = call("POST", "/confirm/logs", "action=delete&confirm=logs")
+	assert.Equal(t, 200, w.Code)
+	tok := token(w)
+	assert.Len(t, tok, 32)
+
+	// Bound to the index and action, and only usable once
+	assert.Equal(t, 400, call("PUT", "/check/other", "action=delete&token="+tok).Code)
+
+	tok = token(call("POST", "/confirm/logs", "action=delete&confirm=logs"))
+	assert.Equal(t, 200, call("PUT", "/check/logs", "action=delete&token="+tok).Code)
+	assert.Equal(t, 400, call("PUT", "/check/logs", "action=delete&token="+tok).Code)
+}
+
+func Test_protectedPatterns(t *testing.T) {
+	bookmarks.Set("prod", bookmarks.Bookmark{Addresses: []string{"http://es1:9200", "http://es2:9200"}, Protected: []string{"prod-*"}, Transient: true})
+	defer bookmarks.Delete("prod")
+
+	assert.Equal(t, []string{"prod-*"}, protectedPatterns(bookmarks.Bookmark{Addresses: []string{"http://ES2:9200/"}}))
+	assert.Empty(t, protectedPatterns(bookmarks.Bookmark{Addresses: []string{"http://es3:9200"}}))
+}
@@ -1,14 +1,17 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"mime"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/client"
 )
 
 var (
@@ -110,6 +113,95 @@ func parseIntFormValue(c *gin.Context, name string, defValue int) (int, error) {
 	return num, nil
 }
 
+// Splits a comma separated form value into trimmed, non-empty items
+func splitList(val string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Parses a comma separated list of "key:value" pairs
+func splitPairs(val string) (map[string]string, error) {
+	pairs := map[string]string{}
+
+	for _, item := range splitList(val) {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid pair %q, expected key:value", item)
+		}
+		pairs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return pairs, nil
+}
+
+// Builds a migration transform from the include, exclude, rename, convert and set form values
+func parseTransform(c *gin.Context) (*client.Transform, error) {
+	var err error
+
+	t := &client.Transform{
+		Include: splitList(c.Request.FormValue("include")),
+		Exclude: splitList(c.Request.FormValue("exclude")),
+	}
+
+	if t.Rename, err = splitPairs(c.Request.FormValue("rename")); err != nil {
+		return nil, fmt.Errorf("rename: %s", err)
+	}
+
+	if t.Convert, err = splitPairs(c.Request.FormValue("convert")); err != nil {
+		return nil, fmt.Errorf("convert: %s", err)
+	}
+
+	if set := strings.TrimSpace(c.Request.FormValue("set")); set != "" {
+		if err := json.Unmarshal([]byte(set), &t.Set); err != nil {
+			return nil, fmt.Errorf("set must be a JSON object: %s", err)
+		}
+	}
+
+	if t.IsEmpty() {
+		return nil, nil
+	}
+
+	return t, t.Validate()
+}
+
+// Reads the migration throughput options: slices, scroll_size, batch_size, keep_alive, workers and max_retries
+func parseMigrateTuning(c *gin.Context, mc *client.MigrateConfig) error {
+	var err error
+
+	if mc.Slices, err = parseIntFormValue(c, "slices", client.DefaultSlices); err != nil {
+		return err
+	}
+	if mc.Size, err = parseIntFormValue(c, "scroll_size", client.DefaultScrollSize); err != nil {
+		return err
+	}
+	if mc.BatchSize, err = parseIntFormValue(c, "batch_size", client.DefaultBatchSize); err != nil {
+		return err
+	}
+	if mc.Workers, err = parseIntFormValue(c, "workers", client.DefaultWorkers); err != nil {
+		return err
+	}
+	if mc.MaxRetries, err = parseIntFormValue(c, "max_retries", client.DefaultMaxRetries); err != nil {
+		return err
+	}
+
+	mc.KeepAlive = client.DefaultKeepAlive
+	if val := strings.TrimSpace(c.Request.FormValue("keep_alive")); val != "" {
+		if mc.KeepAlive, err = time.ParseDuration(val); err != nil || mc.KeepAlive <= 0 {
+			return fmt.Errorf("keep_alive must be a duration such as 1m")
+		}
+	}
+
+	return nil
+}
+
 func assetContentType(name string) string {
 	ext := filepath.Ext(name)
 	result := mime.TypeByExtension(ext)
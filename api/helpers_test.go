@@ -70,3 +70,14 @@ func Test_serveResult(t *testing.T) {
 	assert.Equal(t, 200, w.Code)
 	assert.Equal(t, `null`, w.Body.String())
 }
+
+func Test_splitPairs(t *testing.T) {
+	pairs, err := splitPairs(" a:b, c : d ,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "b", "c": "d"}, pairs)
+
+	_, err = splitPairs("a")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, []string{"a", "b"}, splitList(" a,,b "))
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/ll2l/esweb/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MigrateVerifyOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	old := EsClient
+	defer func() { EsClient = old }()
+	cl, err := client.NewFromConfig(bookmarks.Bookmark{Addresses: []string{"http://127.0.0.1:1"}})
+	assert.NoError(t, err)
+	EsClient = cl
+
+	r := gin.New()
+	mountRoutes(r)
+
+	call := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", path+"&src_index=logs&dst_index=copy&dst_host=http://127.0.0.1:1", nil))
+		return w
+	}
+
+	w := call("/api/migrate?num_items=10&verify=sample")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "cannot be verified")
+
+	w = call("/api/migrate?verify=sample&verify_size=0")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "verify_size")
+
+	w = call("/api/migrate/verify?verify=sample&verify_size=-1")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "verify_size")
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/client"
+)
+
+// parseSavedQuery reads a saved query from the request form
+func parseSavedQuery(c *gin.Context) client.SavedQuery {
+	language := c.Request.FormValue("language")
+	if language == "" {
+		language = client.QuerySQL
+	}
+
+	return client.SavedQuery{
+		Name:        strings.TrimSpace(c.Request.FormValue("name")),
+		Description: strings.TrimSpace(c.Request.FormValue("description")),
+		Tags:        splitList(c.Request.FormValue("tags")),
+		Language:    language,
+		Text:        c.Request.FormValue("text"),
+		Index:       strings.TrimSpace(c.Request.FormValue("index")),
+	}
+}
+
+// GetSavedQueries lists the saved queries of the current cluster, optionally by tag
+func GetSavedQueries(c *gin.Context) {
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	queries, err := client.ListSavedQueries(cl.Alias, c.Request.FormValue("tag"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	list := make([]gin.H, 0, len(queries))
+	for _, q := range queries {
+		list = append(list, gin.H{
+			"name":        q.Name,
+			"description": q.Description,
+			"tags":        q.Tags,
+			"language":    q.Language,
+			"text":        q.Text,
+			"index":       q.Index,
+			"updated":     q.Updated,
+			"params":      q.Params(),
+		})
+	}
+	respondSuccess(c, list)
+}
+
+// CreateSavedQuery saves a new query for the current cluster
+func CreateSavedQuery(c *gin.Context) {
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	q := parseSavedQuery(c)
+	if err := client.SaveQuery(cl.Alias, "", q); err != nil {
+		badRequest(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"name": q.Name, "params": q.Params()})
+}
+
+// UpdateSavedQuery replaces a saved query, the name form value renames it
+func UpdateSavedQuery(c *gin.Context) {
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	name := c.Params.ByName("name")
+	q := parseSavedQuery(c)
+	if q.Name == "" {
+		q.Name = name
+	}
+
+	if _, err := client.GetSavedQuery(cl.Alias, name); err != nil {
+		errorResponse(c, 404, err)
+		return
+	}
+	if err := client.SaveQuery(cl.Alias, name, q); err != nil {
+		badRequest(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"name": q.Name, "params": q.Params()})
+}
+
+// DeleteSavedQuery removes a saved query
+func DeleteSavedQuery(c *gin.Context) {
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	name := c.Params.ByName("name")
+	if err := client.DeleteSavedQuery(cl.Alias, name); err != nil {
+		errorResponse(c, 404, err)
+		return
+	}
+	respondSuccess(c, gin.H{"name": name})
+}
+
+// RunSavedQuery fills in the parameters of a saved query, sent as params[name], and runs it
+func RunSavedQuery(c *gin.Context) {
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	q, err := client.GetSavedQuery(cl.Alias, c.Params.ByName("name"))
+	if err != nil {
+		errorResponse(c, 404, err)
+		return
+	}
+
+	params := c.QueryMap("params")
+	for k, v := range c.PostFormMap("params") {
+		params[k] = v
+	}
+
+	text, err := q.Render(params)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	if q.Language == client.QueryDSL {
+		res, cache, err := cl.SearchWithBody(q.Index, text)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		setCacheHeaders(c, cache)
+		respondSuccess(c, res)
+		return
+	}
+	HandleQuery(text, c)
+}
+
+// ExportSavedQueries downloads the saved queries of the current cluster as a file
+func ExportSavedQueries(c *gin.Context) {
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	queries, err := client.ListSavedQueries(cl.Alias, c.Request.FormValue("tag"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	b, err := json.MarshalIndent(client.SavedQueryFile{Queries: queries}, "", "  ")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	filename := fmt.Sprintf("esweb-queries-%s-%s.json", cl.Alias, time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(200, "application/json", b)
+}
+
+// ImportSavedQueries adds the queries of an exported file, sent as the file upload or
+// the queries form value. Existing names are kept unless overwrite is true.
+func ImportSavedQueries(c *gin.Context) {
+	cl := connectedClient(c)
+	if cl == nil {
+		return
+	}
+
+	var data []byte
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			badRequest(c, err)
+			return
+		}
+		defer f.Close()
+		if data, err = ioutil.ReadAll(f); err != nil {
+			badRequest(c, err)
+			return
+		}
+	} else {
+		data = []byte(c.Request.FormValue("queries"))
+	}
+
+	var file client.SavedQueryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		badRequest(c, fmt.Errorf("invalid saved query file: %s", err))
+		return
+	}
+
+	added, replaced, skipped, err := client.ImportSavedQueries(cl.Alias, file.Queries, c.Request.FormValue("overwrite") == "true")
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"added": added, "replaced": replaced, "skipped": skipped})
+}
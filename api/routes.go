@@ -2,12 +2,16 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/auth"
 	"github.com/ll2l/esweb/ui"
 	"html/template"
 	"io/ioutil"
 	"path"
 )
 
+// PathPrefix is the URL path esweb is mounted under, such as /tools/esweb, empty for the root
+var PathPrefix = ""
+
 func SetupRoutes(r *gin.Engine) {
 	t, err := loadTemplate("index.html")
 	if err != nil {
@@ -15,32 +19,92 @@ func SetupRoutes(r *gin.Engine) {
 	}
 	r.SetHTMLTemplate(t)
 
-	r.GET("/static/*path", GetAsset)
-	r.GET("/", IndexApi)
+	mountRoutes(r)
+}
+
+// mountRoutes registers every route under PathPrefix
+func mountRoutes(r *gin.Engine) {
+	if PathPrefix != "" {
+		// Relative URLs need the trailing slash
+		r.GET(PathPrefix, func(c *gin.Context) {
+			c.Redirect(301, PathPrefix+"/")
+		})
+		r.GET("/", func(c *gin.Context) {
+			c.Redirect(302, PathPrefix+"/")
+		})
+	}
+
+	root := r.Group(PathPrefix + "/")
+	root.GET("/static/*path", GetAsset)
+	root.GET("/", IndexApi)
+
+	read := authorize(auth.PermRead)
+	query := authorize(auth.PermQuery)
+	migrate := authorize(auth.PermMigrate)
+	manageQueries := authorize(auth.PermQueries)
+	manageTemplates := authorize(auth.PermTemplates)
+
+	// Bookmarks and switching clusters do not use the active connection
+	browse := permit(auth.PermRead)
+	manageBookmarks := permit(auth.PermBookmarks)
 
-	apiGroup := r.Group("/api")
-	apiGroup.GET("/objects", GetObjects)
-	apiGroup.POST("/connect", Connect)
-	apiGroup.GET("/connection", GetConnectionInfo)
-	apiGroup.GET("/bookmarks", GetBookmarks)
-	apiGroup.POST("/switchdb", SwitchCluster)
-	apiGroup.GET("/info", GetInfo)
-	apiGroup.GET("/clusters", GetClusters)
-	apiGroup.GET("/databases", GetClusters)
-	apiGroup.GET("/indices/:index/info", GetIndexInfo)
-	apiGroup.PUT("/indices/:index", ManageIndex)
-	apiGroup.GET("/tables/:table/rows", GetIndexRows)
-	apiGroup.GET("/query", RunQuery)
-	apiGroup.POST("/query", RunQuery)
-	apiGroup.GET("/mapping/:index", GetMapping)
-	apiGroup.GET("/kibana", GetKibana)
-	apiGroup.GET("/export", DataExport)
-	apiGroup.POST("/migrate", Migrate)
-	apiGroup.GET("/history", GetHistory)
-	apiGroup.GET("/dsl", GetDsl)
-	apiGroup.GET("/settings/:index", GetSettings)
-	apiGroup.GET("/stats/:index", GetStats)
-	apiGroup.GET("/tasks", GetTasks)
+	apiGroup := root.Group("/api")
+	apiGroup.Use(bindRequest)
+	apiGroup.GET("/me", GetMe)
+	apiGroup.GET("/objects", read, GetObjects)
+	apiGroup.POST("/connect", audited("connect", "host", "cloud_id"), browse, Connect)
+	apiGroup.GET("/connection", read, GetConnectionInfo)
+	apiGroup.GET("/bookmarks", browse, GetBookmarks)
+	apiGroup.POST("/bookmarks", audited("bookmark:create", "name"), manageBookmarks, CreateBookmark)
+	apiGroup.POST("/bookmarks/test", permit(auth.PermConnect), TestBookmark)
+	apiGroup.PUT("/bookmarks/:name", audited("bookmark:update", "name"), manageBookmarks, UpdateBookmark)
+	apiGroup.DELETE("/bookmarks/:name", audited("bookmark:delete", "name"), manageBookmarks, DeleteBookmark)
+	apiGroup.POST("/switchdb", audited("switch", "cluster"), browse, SwitchCluster)
+	apiGroup.GET("/info", read, GetInfo)
+	apiGroup.GET("/clusters", browse, GetClusters)
+	apiGroup.GET("/databases", browse, GetClusters)
+	apiGroup.GET("/indices/:index/info", read, GetIndexInfo)
+	apiGroup.PUT("/indices/:index", audited("index", "index"), read, ManageIndex)
+	apiGroup.POST("/indices/:index/confirm", read, ConfirmIndexAction)
+	apiGroup.GET("/tables/:table/rows", read, GetIndexRows)
+	apiGroup.GET("/query", query, RunQuery)
+	apiGroup.POST("/query", query, RunQuery)
+	apiGroup.POST("/cancel/:id", query, CancelQuery)
+	apiGroup.GET("/mapping/:index", read, GetMapping)
+	apiGroup.GET("/kibana", read, GetKibana)
+	apiGroup.GET("/export", query, DataExport)
+	apiGroup.POST("/migrate", audited("migrate", "src_index", "dst_index"), migrate, Migrate)
+	apiGroup.POST("/migrate/preview", migrate, MigratePreview)
+	apiGroup.GET("/migrate/status", migrate, GetMigrateStatus)
+	apiGroup.GET("/migrate/deadletters", migrate, GetDeadLetters)
+	apiGroup.GET("/migrate/deadletters/:name", migrate, DownloadDeadLetter)
+	apiGroup.POST("/migrate/replay", audited("migrate:replay", "dst_index"), migrate, ReplayDeadLetter)
+	apiGroup.POST("/migrate/verify", migrate, VerifyMigration)
+	apiGroup.GET("/migrate/reports/:name", migrate, DownloadVerifyReport)
+	apiGroup.GET("/history", read, GetHistory)
+	apiGroup.GET("/queries", query, GetSavedQueries)
+	apiGroup.POST("/queries", audited("query:create", "name"), manageQueries, CreateSavedQuery)
+	apiGroup.PUT("/queries/:name", audited("query:update", "name"), manageQueries, UpdateSavedQuery)
+	apiGroup.DELETE("/queries/:name", audited("query:delete", "name"), manageQueries, DeleteSavedQuery)
+	apiGroup.POST("/queries/run/:name", query, RunSavedQuery)
+	apiGroup.GET("/queries/export", query, ExportSavedQueries)
+	apiGroup.POST("/queries/import", audited("query:import"), manageQueries, ImportSavedQueries)
+	apiGroup.GET("/templates", query, GetSearchTemplates)
+	apiGroup.GET("/templates/:id", query, GetSearchTemplate)
+	apiGroup.PUT("/templates/:id", audited("template:put", "id"), manageTemplates, PutSearchTemplate)
+	apiGroup.DELETE("/templates/:id", audited("template:delete", "id"), manageTemplates, DeleteSearchTemplate)
+	apiGroup.POST("/templates/:id/render", query, RenderSearchTemplate)
+	apiGroup.GET("/templates/:id/run", query, RunSearchTemplate)
+	apiGroup.POST("/templates/:id/run", query, RunSearchTemplate)
+	apiGroup.GET("/dsl", query, GetDsl)
+	apiGroup.GET("/autocomplete", read, GetAutocomplete)
+	apiGroup.GET("/settings/:index", read, GetSettings)
+	apiGroup.GET("/stats/:index", read, GetStats)
+	apiGroup.GET("/tasks", read, GetTasks)
+	apiGroup.GET("/query-stats", query, GetQueryStats)
+	apiGroup.DELETE("/query-stats", audited("query-stats:reset"), authorize(auth.PermAll), ResetQueryStats)
+	apiGroup.GET("/slowlog", query, GetSlowLog)
+	apiGroup.GET("/audit", permit(auth.PermAudit), GetAuditLog)
 }
 
 func loadTemplate(name string) (*template.Template, error) {
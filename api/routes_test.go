@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SetupRoutesPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	PathPrefix = "/tools/esweb"
+	defer func() { PathPrefix = "" }()
+
+	r := gin.New()
+	mountRoutes(r)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/tools/esweb")
+	assert.Equal(t, 301, w.Code)
+	assert.Equal(t, "/tools/esweb/", w.Header().Get("Location"))
+
+	assert.Equal(t, 302, get("/").Code)
+	assert.Equal(t, 200, get("/tools/esweb/api/bookmarks").Code)
+	assert.Equal(t, 404, get("/api/bookmarks").Code)
+}
+
+func Test_NotConnected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	old := EsClient
+	defer func() { EsClient = old }()
+	EsClient = nil
+
+	r := gin.New()
+	mountRoutes(r)
+
+	for _, route := range [][2]string{
+		{"GET", "/api/queries"},
+		{"POST", "/api/queries?name=q&text=select+1"},
+		{"PUT", "/api/queries/q"},
+		{"DELETE", "/api/queries/q"},
+		{"POST", "/api/queries/run/q"},
+		{"GET", "/api/queries/export"},
+		{"POST", "/api/queries/import"},
+		{"PUT", "/api/indices/logs?action=refresh"},
+		{"POST", "/api/indices/logs/confirm?action=delete&confirm=logs"},
+		{"POST", "/api/migrate?src_index=a&dst_index=b&dst_host=http://127.0.0.1:1"},
+		{"POST", "/api/migrate/preview?src_index=a&dst_index=b&dst_host=http://127.0.0.1:1"},
+		{"POST", "/api/migrate/verify?src_index=a&dst_index=b&dst_host=http://127.0.0.1:1"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(route[0], route[1], nil))
+		assert.Equal(t, 400, w.Code, route[0]+" "+route[1])
+		assert.Contains(t, w.Body.String(), "not connected", route[0]+" "+route[1])
+	}
+
+	// The editors still get their keywords
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/autocomplete?index=logs", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"SELECT"`)
+}
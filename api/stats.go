@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/auth"
+	"github.com/ll2l/esweb/client"
+)
+
+// statsCluster is the cluster whose query statistics are used, all clusters with
+// all=true for admins
+func statsCluster(c *gin.Context) (string, bool) {
+	if EsClient == nil || c.Request.FormValue("all") == "true" {
+		return "", allowed(c, auth.PermAll)
+	}
+	return EsClient.Alias, true
+}
+
+// GetQueryStats returns the query statistics by query shape, sorted by total time or
+// by the sort form value: count, p50, p95 or max
+func GetQueryStats(c *gin.Context) {
+	limit, err := parseIntFormValue(c, "limit", 100)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	var key func(s client.QueryStats) int64
+	switch by := c.Request.FormValue("sort"); by {
+	case "", "total":
+	case "count":
+		key = func(s client.QueryStats) int64 { return s.Count }
+	case "p50":
+		key = func(s client.QueryStats) int64 { return s.P50 }
+	case "p95":
+		key = func(s client.QueryStats) int64 { return s.P95 }
+	case "max":
+		key = func(s client.QueryStats) int64 { return s.Max }
+	default:
+		badRequest(c, fmt.Errorf("invalid sort %q", by))
+		return
+	}
+
+	cluster, ok := statsCluster(c)
+	if !ok {
+		return
+	}
+
+	stats := client.GetQueryStats(cluster)
+	if key != nil {
+		sort.SliceStable(stats, func(i, j int) bool { return key(stats[i]) > key(stats[j]) })
+	}
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	respondSuccess(c, stats)
+}
+
+// ResetQueryStats forgets the query statistics
+func ResetQueryStats(c *gin.Context) {
+	cluster, ok := statsCluster(c)
+	if !ok {
+		return
+	}
+
+	client.ResetQueryStats(cluster)
+	respondSuccess(c, gin.H{"reset": true})
+}
+
+// GetSlowLog returns the newest queries of the slow log
+func GetSlowLog(c *gin.Context) {
+	limit, err := parseIntFormValue(c, "limit", 100)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	cluster, ok := statsCluster(c)
+	if !ok {
+		return
+	}
+
+	records, err := client.ReadSlowLog(cluster, limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondSuccess(c, records)
+}
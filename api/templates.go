@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ll2l/esweb/client"
+)
+
+// templateParams reads the template params, a JSON object in params or params[name] values
+func templateParams(c *gin.Context) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	if raw := strings.TrimSpace(c.Request.FormValue("params")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &params); err != nil {
+			return nil, fmt.Errorf("params must be a JSON object: %s", err)
+		}
+		return params, nil
+	}
+
+	for k, v := range c.QueryMap("params") {
+		params[k] = v
+	}
+	for k, v := range c.PostFormMap("params") {
+		params[k] = v
+	}
+	return params, nil
+}
+
+// GetSearchTemplates lists the stored mustache search templates
+func GetSearchTemplates(c *gin.Context) {
+	res, err := esClient(c).SearchTemplates()
+	serveResult(c, res, err)
+}
+
+// GetSearchTemplate returns a stored search template with its params
+func GetSearchTemplate(c *gin.Context) {
+	res, err := esClient(c).GetSearchTemplate(c.Params.ByName("id"))
+	if err != nil {
+		errorResponse(c, 404, err)
+		return
+	}
+	respondSuccess(c, res)
+}
+
+// PutSearchTemplate creates or replaces a stored search template, overwrite=true
+// replaces a stored script of another language
+func PutSearchTemplate(c *gin.Context) {
+	id := c.Params.ByName("id")
+	err := esClient(c).PutSearchTemplate(id, c.Request.FormValue("source"), c.Request.FormValue("overwrite") == "true")
+	if _, ok := err.(*client.ScriptConflictError); ok {
+		errorResponse(c, 409, err)
+		return
+	}
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"id": id})
+}
+
+// DeleteSearchTemplate removes a stored search template
+func DeleteSearchTemplate(c *gin.Context) {
+	id := c.Params.ByName("id")
+	if err := esClient(c).DeleteSearchTemplate(id); err != nil {
+		respondError(c, err)
+		return
+	}
+	respondSuccess(c, gin.H{"id": id})
+}
+
+// RenderSearchTemplate shows the search body a template expands to for the params
+func RenderSearchTemplate(c *gin.Context) {
+	params, err := templateParams(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	res, err := esClient(c).RenderSearchTemplate(c.Params.ByName("id"), params)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	respondSuccess(c, res)
+}
+
+// RunSearchTemplate searches the index form value with a template, results are
+// served like query results, including the format query parameter
+func RunSearchTemplate(c *gin.Context) {
+	params, err := templateParams(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	res, err := esClient(c).RunSearchTemplate(c.Request.FormValue("index"), c.Params.ByName("id"), params)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	serveSearchResult(c, res)
+}
@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of a record
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Redacted replaces the value of secret parameters
+const Redacted = "[redacted]"
+
+// Longer parameter values, such as mappings, are cut
+const maxParamLen = 1024
+
+// Parameters which hold secrets, anything named like a password or token is redacted too
+var secretParams = map[string]bool{
+	"pass":         true,
+	"dst_pass":     true,
+	"api_key":      true,
+	"bearer_token": true,
+	"client_key":   true,
+}
+
+// Record is one state-changing call, written as a JSON line
+type Record struct {
+	Time     time.Time         `json:"time"`
+	User     string            `json:"user"`
+	ClientIP string            `json:"client_ip"`
+	Cluster  string            `json:"cluster"`
+	Action   string            `json:"action"`
+	Targets  []string          `json:"targets,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	Outcome  string            `json:"outcome"`
+	Status   int               `json:"status"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Filter selects records read back from the log
+type Filter struct {
+	User   string
+	Action string // Prefix of the action, index matches index:delete
+	Target string
+	Limit  int
+}
+
+var (
+	mu sync.Mutex
+
+	// File is the audit log, nothing is recorded when empty
+	File string
+)
+
+// Write appends a record to the audit log
+func Write(r Record) error {
+	if File == "" {
+		return nil
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, err := os.OpenFile(File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Read returns the newest records matching the filter, newest first
+func Read(filter Filter) ([]Record, error) {
+	records := []Record{}
+	if File == "" {
+		return records, nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, err := os.Open(File)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A line cut by a crash, keep reading
+			continue
+		}
+		if !filter.match(r) {
+			continue
+		}
+		records = append(records, r)
+		if filter.Limit > 0 && len(records) > filter.Limit {
+			records = records[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+func (f Filter) match(r Record) bool {
+	if f.User != "" && r.User != f.User {
+		return false
+	}
+	if f.Action != "" && !strings.HasPrefix(r.Action, f.Action) {
+		return false
+	}
+	if f.Target != "" {
+		for _, t := range r.Targets {
+			if t == f.Target {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Params returns the request parameters with secrets redacted and long values cut
+func Params(values map[string][]string) map[string]string {
+	params := map[string]string{}
+	for k, v := range values {
+		val := strings.Join(v, ",")
+		switch {
+		case val == "":
+			continue
+		case isSecret(k):
+			val = Redacted
+		case len(val) > maxParamLen:
+			val = val[:maxParamLen] + "..."
+		}
+		params[k] = val
+	}
+	return params
+}
+
+func isSecret(name string) bool {
+	name = strings.ToLower(name)
+	return secretParams[name] || strings.Contains(name, "password") || strings.Contains(name, "token") ||
+		strings.Contains(name, "secret")
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pbkdf2(t *testing.T) {
+	// RFC 6070 test vectors
+	key := pbkdf2([]byte("password"), []byte("salt"), 2, 20, sha1.New)
+	assert.Equal(t, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957", hex.EncodeToString(key))
+
+	key = pbkdf2([]byte("passwordPASSWORDpassword"), []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), 4096, 25, sha1.New)
+	assert.Equal(t, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038", hex.EncodeToString(key))
+}
+
+func Test_HashPassword(t *testing.T) {
+	hash, err := HashPassword("s3cret")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "pbkdf2_sha256$120000$"))
+	assert.NotContains(t, hash, "s3cret")
+
+	assert.True(t, CheckPassword(hash, "s3cret"))
+	assert.False(t, CheckPassword(hash, "s3cret "))
+	assert.False(t, CheckPassword("s3cret", "s3cret"))
+	assert.False(t, CheckPassword("pbkdf2_sha256$x$AA$AA", "s3cret"))
+
+	other, _ := HashPassword("s3cret")
+	assert.NotEqual(t, hash, other)
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// Built-in roles
+const (
+	RoleViewer   = "viewer"   // Browse and query
+	RoleOperator = "operator" // Also connect, migrate and run non destructive index actions
+	RoleAdmin    = "admin"    // Everything, including destructive index actions and bookmarks
+)
+
+// Permissions checked by the API
+const (
+	PermRead      = "read"      // Browse indices, mappings, settings and rows
+	PermQuery     = "query"     // Run queries and exports
+	PermConnect   = "connect"   // Connect to clusters which are not bookmarked
+	PermMigrate   = "migrate"   // Migrate, verify and replay
+	PermBookmarks = "bookmarks" // Create, change and delete bookmarks
+	PermAudit     = "audit"     // Read the audit log
+	PermQueries   = "queries"   // Save, change and delete saved queries
+	PermTemplates = "templates" // Store and delete search templates
+	PermAll       = "*"
+
+	// PermIndex prefixes a ManageIndex action, such as index:delete
+	PermIndex = "index:"
+)
+
+var rolePermissions = map[string][]string{
+	RoleViewer: {PermRead, PermQuery},
+	RoleOperator: {
+		PermRead, PermQuery, PermConnect, PermMigrate, PermQueries, PermTemplates,
+		PermIndex + "refresh", PermIndex + "flush", PermIndex + "merge", PermIndex + "clear_cache", PermIndex + "open",
+	},
+	RoleAdmin: {PermAll},
+}
+
+// User is an esweb account
+type User struct {
+	Name     string   `json:"name"`
+	Password string   `json:"-"` // Hash made by HashPassword
+	Roles    []string `json:"roles"`
+}
+
+var (
+	mu    sync.RWMutex
+	users map[string]*User
+
+	// Credentials checked recently, PBKDF2 is too slow to run on every request
+	verified = map[string][32]byte{}
+)
+
+// Enabled reports whether users are configured, without users everybody may do everything
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return users != nil
+}
+
+// Load reads the users file, every top level table is a user:
+//
+//	[alice]
+//	password = "pbkdf2_sha256$..."
+//	roles = ["admin"]
+func Load(path string) error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("cannot read users file %s: %s", path, err)
+	}
+
+	loaded := map[string]*User{}
+	for name, conf := range v.AllSettings() {
+		u := &User{Name: name}
+		if err := mapstructure.Decode(conf, u); err != nil {
+			return fmt.Errorf("user %s: %s", name, err)
+		}
+		u.Name = name
+		if err := u.validate(); err != nil {
+			return err
+		}
+		loaded[name] = u
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	users = loaded
+	verified = map[string][32]byte{}
+	return nil
+}
+
+// AddUser adds an account, used for the --auth-user account
+func AddUser(u *User) error {
+	if err := u.validate(); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if users == nil {
+		users = map[string]*User{}
+	}
+	users[u.Name] = u
+	return nil
+}
+
+// Reset removes all users, which disables authentication
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	users = nil
+	verified = map[string][32]byte{}
+}
+
+func (u *User) validate() error {
+	if !strings.HasPrefix(u.Password, hashScheme+"$") {
+		return fmt.Errorf("user %s: password must be a %s hash, see --hash-password", u.Name, hashScheme)
+	}
+	if len(u.Roles) == 0 {
+		return fmt.Errorf("user %s has no roles", u.Name)
+	}
+	for _, r := range u.Roles {
+		if _, ok := rolePermissions[r]; !ok {
+			return fmt.Errorf("user %s: unknown role %q", u.Name, r)
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the user with this name and password
+func Authenticate(name, password string) (*User, bool) {
+	mu.RLock()
+	u, ok := users[name]
+	sum, seen := verified[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	check := sha256.Sum256([]byte(u.Password + "\x00" + password))
+	if seen && sum == check {
+		return u, true
+	}
+	if !CheckPassword(u.Password, password) {
+		return nil, false
+	}
+
+	mu.Lock()
+	verified[name] = check
+	mu.Unlock()
+	return u, true
+}
+
+// Can reports whether the user has a permission, a nil user stands for disabled authentication
+func (u *User) Can(perm string) bool {
+	if u == nil {
+		return true
+	}
+	for _, r := range u.Roles {
+		for _, p := range rolePermissions[r] {
+			if p == PermAll || p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanSee reports whether the user may use a bookmark restricted to roles, none means everybody
+func (u *User) CanSee(roles []string) bool {
+	if u == nil || len(roles) == 0 || u.Can(PermAll) {
+		return true
+	}
+	for _, r := range roles {
+		for _, ur := range u.Roles {
+			if r == ur {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions of the user
+func (u *User) Permissions() []string {
+	if u == nil {
+		return []string{PermAll}
+	}
+
+	set := map[string]bool{}
+	for _, r := range u.Roles {
+		for _, p := range rolePermissions[r] {
+			set[p] = true
+		}
+	}
+	perms := make([]string, 0, len(set))
+	for p := range set {
+		perms = append(perms, p)
+	}
+	sort.Strings(perms)
+	return perms
+}
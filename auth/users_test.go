@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Load(t *testing.T) {
+	defer Reset()
+
+	dir, err := ioutil.TempDir("", "esweb-users")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hash, _ := HashPassword("alice-pass")
+	file := filepath.Join(dir, "users.toml")
+	write := func(content string) {
+		assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	}
+
+	write(fmt.Sprintf("[alice]\npassword = %q\nroles = [\"operator\"]\n", hash))
+	assert.NoError(t, Load(file))
+	assert.True(t, Enabled())
+
+	u, ok := Authenticate("alice", "alice-pass")
+	assert.True(t, ok)
+	assert.Equal(t, []string{RoleOperator}, u.Roles)
+
+	// Second check is served from the cache, a wrong password still fails
+	_, ok = Authenticate("alice", "alice-pass")
+	assert.True(t, ok)
+	_, ok = Authenticate("alice", "wrong")
+	assert.False(t, ok)
+	_, ok = Authenticate("bob", "alice-pass")
+	assert.False(t, ok)
+
+	write("[bob]\npassword = \"plain\"\nroles = [\"admin\"]\n")
+	assert.Error(t, Load(file))
+
+	write(fmt.Sprintf("[bob]\npassword = %q\nroles = [\"root\"]\n", hash))
+	assert.Error(t, Load(file))
+}
+
+func Test_UserCan(t *testing.T) {
+	var nobody *User
+	assert.True(t, nobody.Can(PermIndex+"delete"))
+	assert.True(t, nobody.CanSee([]string{RoleAdmin}))
+
+	viewer := &User{Name: "v", Roles: []string{RoleViewer}}
+	assert.True(t, viewer.Can(PermQuery))
+	assert.False(t, viewer.Can(PermConnect))
+	assert.False(t, viewer.Can(PermIndex+"refresh"))
+	assert.True(t, viewer.CanSee(nil))
+	assert.True(t, viewer.CanSee([]string{RoleViewer, RoleOperator}))
+	assert.False(t, viewer.CanSee([]string{RoleOperator}))
+
+	operator := &User{Name: "o", Roles: []string{RoleOperator}}
+	assert.True(t, operator.Can(PermIndex+"refresh"))
+	assert.False(t, operator.Can(PermIndex+"delete"))
+	assert.False(t, operator.Can(PermBookmarks))
+
+	admin := &User{Name: "a", Roles: []string{RoleAdmin}}
+	assert.True(t, admin.Can(PermIndex+"delete"))
+	assert.True(t, admin.CanSee([]string{RoleViewer}))
+	assert.Equal(t, []string{PermAll}, admin.Permissions())
+}
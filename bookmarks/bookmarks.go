@@ -2,20 +2,148 @@ package bookmarks
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
 )
 
 // Bookmark contains information about bookmarked cluster connection
 type Bookmark struct {
 	Addresses []string `json:"addresses"`
 	User      string   `json:"user"`
-	Password  string   `json:"password"`
+	Password  string   `json:"password,omitempty"`
+	APIKey    string   `json:"api_key,omitempty" mapstructure:"api_key"`
 	Alias     string   `json:"alias"`
 	Kibana    string   `json:"kibana"`
+
+	BearerToken string `json:"bearer_token,omitempty" mapstructure:"bearer_token"`
+	CloudID     string `json:"cloud_id,omitempty" mapstructure:"cloud_id"`       // Elastic Cloud deployment, replaces Addresses
+	CACert      string `json:"ca_cert,omitempty" mapstructure:"ca_cert"`         // PEM file or inline PEM
+	ClientCert  string `json:"client_cert,omitempty" mapstructure:"client_cert"` // PEM file or inline PEM
+	ClientKey   string `json:"client_key,omitempty" mapstructure:"client_key"`   // PEM file or inline PEM
+	Insecure    bool   `json:"insecure,omitempty" mapstructure:"insecure"`       // Skip TLS certificate verification
+
+	// Roles of the esweb users who may see the bookmark, everybody when empty
+	Roles []string `json:"roles,omitempty" mapstructure:"roles"`
+
+	// Index patterns, such as prod-* or .security*, which cannot be deleted, closed or frozen
+	Protected []string `json:"protected,omitempty" mapstructure:"protected"`
+
+	// Set on bookmarks which only live in memory, such as the fallback default
+	// bookmark or clusters connected to without saving them
+	Transient bool `json:"transient,omitempty" mapstructure:"-"`
+}
+
+var (
+	mu       sync.RWMutex
+	Clusters = map[string]Bookmark{}
+
+	// File is the bookmark file changes are written to, its extension decides the format
+	File string
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Public returns a copy of the bookmark without its secrets, secret references are kept
+func (b Bookmark) Public() Bookmark {
+	if !IsReference(b.Password) {
+		b.Password = ""
+	}
+	if !IsReference(b.APIKey) {
+		b.APIKey = ""
+	}
+	if !IsReference(b.BearerToken) {
+		b.BearerToken = ""
+	}
+	if isInlinePEM(b.ClientKey) {
+		b.ClientKey = ""
+	}
+	return b
 }
 
-var Clusters = map[string]Bookmark{}
+func isInlinePEM(val string) bool {
+	return strings.HasPrefix(strings.TrimSpace(val), "-----BEGIN")
+}
+
+// Validate checks the bookmark can be used for a connection
+func (b Bookmark) Validate() error {
+	for _, p := range b.Protected {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid protected index pattern %q", p)
+		}
+	}
+	if b.CloudID != "" {
+		if len(b.Addresses) > 0 {
+			return fmt.Errorf("set either addresses or a cloud id, not both")
+		}
+		return nil
+	}
+	if len(b.Addresses) == 0 {
+		return fmt.Errorf("bookmark needs at least one address or a cloud id")
+	}
+	for _, a := range b.Addresses {
+		if !strings.HasPrefix(a, "http://") && !strings.HasPrefix(a, "https://") {
+			return fmt.Errorf("invalid address %q, it must start with http:// or https://", a)
+		}
+	}
+	return nil
+}
+
+// settings returns the bookmark as written to the bookmark file
+func (b Bookmark) settings() map[string]interface{} {
+	s := map[string]interface{}{}
+	if len(b.Addresses) > 0 {
+		s["addresses"] = b.Addresses
+	}
+	values := map[string]string{
+		"user":         b.User,
+		"password":     b.Password,
+		"api_key":      b.APIKey,
+		"alias":        b.Alias,
+		"kibana":       b.Kibana,
+		"bearer_token": b.BearerToken,
+		"cloud_id":     b.CloudID,
+		"ca_cert":      b.CACert,
+		"client_cert":  b.ClientCert,
+		"client_key":   b.ClientKey,
+	}
+	for k, v := range values {
+		if v != "" {
+			s[k] = v
+		}
+	}
+	if b.Insecure {
+		s["insecure"] = true
+	}
+	if len(b.Roles) > 0 {
+		s["roles"] = b.Roles
+	}
+	if len(b.Protected) > 0 {
+		s["protected"] = b.Protected
+	}
+	return s
+}
+
+// NormalizeName returns the name a bookmark is stored under, bookmark file keys are case insensitive
+func NormalizeName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid bookmark name %q, use letters, digits, - and _", name)
+	}
+	return name, nil
+}
 
 func GetClusterConfig(clusterName string) (Bookmark, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	conf, ok := Clusters[clusterName]
 	if !ok {
 		return Bookmark{}, fmt.Errorf("couldn't find a config with name %s", clusterName)
@@ -25,16 +153,157 @@ func GetClusterConfig(clusterName string) (Bookmark, error) {
 
 // return all cluster name
 func GetBookmarks() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	c := make([]string, 0, len(Clusters))
 	for k := range Clusters {
 		c = append(c, k)
 	}
+	sort.Strings(c)
 	return c
 
 }
 
+// List returns all bookmarks without their passwords
+func List() map[string]Bookmark {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	l := make(map[string]Bookmark, len(Clusters))
+	for name, b := range Clusters {
+		l[name] = b.Public()
+	}
+	return l
+}
+
+// Set adds or replaces a bookmark in memory
+func Set(name string, b Bookmark) {
+	mu.Lock()
+	defer mu.Unlock()
+	Clusters[name] = b
+}
+
+// Create adds a bookmark and saves the bookmark file
+func Create(name string, b Bookmark) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if old, ok := Clusters[name]; ok && !old.Transient {
+		return fmt.Errorf("bookmark %s already exists", name)
+	}
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	b.Transient = false
+	Clusters[name] = b
+	if err := save(); err != nil {
+		delete(Clusters, name)
+		return err
+	}
+	return nil
+}
+
+// Update replaces the bookmark name, renaming it to newName when that differs,
+// and saves the bookmark file. Empty secrets (password, API key, bearer token,
+// client key) keep the current ones unless clearPassword is set.
+func Update(name, newName string, b Bookmark, clearPassword bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	old, ok := Clusters[name]
+	if !ok {
+		return fmt.Errorf("couldn't find a config with name %s", name)
+	}
+	if _, ok := Clusters[newName]; ok && newName != name {
+		return fmt.Errorf("bookmark %s already exists", newName)
+	}
+	if b.Password == "" && !clearPassword {
+		b.Password = old.Password
+	}
+	if b.APIKey == "" && !clearPassword {
+		b.APIKey = old.APIKey
+	}
+	if b.BearerToken == "" && !clearPassword {
+		b.BearerToken = old.BearerToken
+	}
+	if b.ClientKey == "" && !clearPassword {
+		b.ClientKey = old.ClientKey
+	}
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	b.Transient = false
+	delete(Clusters, name)
+	Clusters[newName] = b
+	if err := save(); err != nil {
+		delete(Clusters, newName)
+		Clusters[name] = old
+		return err
+	}
+	return nil
+}
+
+// Delete removes a bookmark and saves the bookmark file
+func Delete(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	old, ok := Clusters[name]
+	if !ok {
+		return fmt.Errorf("couldn't find a config with name %s", name)
+	}
+
+	delete(Clusters, name)
+	if old.Transient {
+		return nil
+	}
+	if err := save(); err != nil {
+		Clusters[name] = old
+		return err
+	}
+	return nil
+}
+
+// save writes the bookmarks to File in the format of its extension. The file is
+// written next to the old one and renamed over it, so it is never left half written.
+func save() error {
+	if File == "" {
+		return fmt.Errorf("no bookmark file configured")
+	}
+
+	ext := filepath.Ext(File)
+	tmp, err := ioutil.TempFile(filepath.Dir(File), "."+strings.TrimSuffix(filepath.Base(File), ext)+"-*"+ext)
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	v := viper.New()
+	for name, b := range Clusters {
+		if !b.Transient {
+			v.Set(name, b.settings())
+		}
+	}
+	if err := v.WriteConfigAs(tmp.Name()); err != nil {
+		return fmt.Errorf("cannot write bookmark file: %s", err)
+	}
+
+	// Bookmarks hold passwords
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), File)
+}
+
 // return all cluster name
 func GetKibanaUrlByAlias(alias string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	for _, v := range Clusters {
 		if v.Alias == alias {
 			return v.Kibana
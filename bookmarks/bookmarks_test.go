@@ -0,0 +1,65 @@
+package bookmarks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func readFile(t *testing.T, path string) map[string]interface{} {
+	v := viper.New()
+	v.SetConfigFile(path)
+	assert.NoError(t, v.ReadInConfig())
+	return v.AllSettings()
+}
+
+func Test_bookmarkCRUD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bookmarks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, ext := range []string{".toml", ".yaml", ".json"} {
+		Clusters = map[string]Bookmark{"default": {Addresses: []string{"http://localhost:9200"}, Transient: true}}
+		File = filepath.Join(dir, "bookmark"+ext)
+
+		prod := Bookmark{Addresses: []string{"http://es:9200"}, User: "elastic", Password: "secret", Alias: "prod"}
+		assert.NoError(t, Create("prod", prod))
+		assert.Error(t, Create("prod", prod))
+		assert.Error(t, Create("bad", Bookmark{Addresses: []string{"es:9200"}}))
+
+		saved := readFile(t, File)
+		assert.NotContains(t, saved, "default")
+		assert.Equal(t, "secret", saved["prod"].(map[string]interface{})["password"])
+
+		assert.Equal(t, "", List()["prod"].Password)
+
+		// Renaming keeps the password when none is sent
+		assert.NoError(t, Update("prod", "production", Bookmark{Addresses: []string{"https://es:9200"}, User: "elastic"}, false))
+		saved = readFile(t, File)
+		assert.NotContains(t, saved, "prod")
+		assert.Equal(t, "secret", saved["production"].(map[string]interface{})["password"])
+
+		assert.NoError(t, Update("production", "production", Bookmark{Addresses: []string{"https://es:9200"}}, true))
+		assert.NotContains(t, readFile(t, File)["production"], "password")
+
+		assert.NoError(t, Delete("production"))
+		assert.Error(t, Delete("production"))
+		assert.Empty(t, readFile(t, File))
+
+		files, _ := filepath.Glob(filepath.Join(dir, ".bookmark-*"))
+		assert.Empty(t, files)
+	}
+}
+
+func Test_NormalizeName(t *testing.T) {
+	name, err := NormalizeName(" Prod_1 ")
+	assert.NoError(t, err)
+	assert.Equal(t, "prod_1", name)
+
+	_, err = NormalizeName("prod.eu")
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package bookmarks
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Secret reference prefixes. A bookmark user, password, API key or bearer token starting with
+// one of them is read from the environment, a file or a command at connect time,
+// any other value is used as it is.
+const (
+	refEnv  = "env:"  // env:ES_PASSWORD
+	refFile = "file:" // file:/run/secrets/es-password
+	refCmd  = "cmd:"  // cmd:vault kv get -field=password secret/es, run without a shell
+)
+
+// Time allowed to a secret command
+var secretCmdTimeout = 10 * time.Second
+
+// IsReference reports whether a bookmark value is a secret reference
+func IsReference(val string) bool {
+	return strings.HasPrefix(val, refEnv) || strings.HasPrefix(val, refFile) || strings.HasPrefix(val, refCmd)
+}
+
+// CheckNoReferences returns an error when a connection option is a secret reference.
+// References only come from the bookmark file an operator edits, options sent over
+// HTTP are used as they are and cannot be references.
+func (b Bookmark) CheckNoReferences() error {
+	options := [][2]string{
+		{"user", b.User},
+		{"password", b.Password},
+		{"api_key", b.APIKey},
+		{"bearer_token", b.BearerToken},
+		{"cloud_id", b.CloudID},
+		{"ca_cert", b.CACert},
+		{"client_cert", b.ClientCert},
+		{"client_key", b.ClientKey},
+		{"alias", b.Alias},
+		{"kibana", b.Kibana},
+	}
+	for _, addr := range b.Addresses {
+		options = append(options, [2]string{"host", addr})
+	}
+
+	for _, o := range options {
+		if IsReference(strings.TrimSpace(o[1])) {
+			return fmt.Errorf("%s cannot be an env:, file: or cmd: reference", o[0])
+		}
+	}
+	return nil
+}
+
+// Resolve returns the value a secret reference points to, other values are returned
+// unchanged. Errors name the reference but never the secret.
+func Resolve(val string) (string, error) {
+	switch {
+	case strings.HasPrefix(val, refEnv):
+		name := strings.TrimPrefix(val, refEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(val, refFile):
+		path := strings.TrimPrefix(val, refFile)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read secret file %s: %s", path, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(val, refCmd):
+		args := strings.Fields(strings.TrimPrefix(val, refCmd))
+		if len(args) == 0 {
+			return "", fmt.Errorf("empty secret command")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), secretCmdTimeout)
+		defer cancel()
+
+		// The output is the secret, it is left out of errors
+		out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("secret command %s failed: %s", args[0], err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+	return val, nil
+}
+
+// Resolved returns a copy of the bookmark with its secret references resolved
+func (b Bookmark) Resolved() (Bookmark, error) {
+	var err error
+	if b.User, err = Resolve(b.User); err != nil {
+		return b, fmt.Errorf("user: %s", err)
+	}
+	if b.Password, err = Resolve(b.Password); err != nil {
+		return b, fmt.Errorf("password: %s", err)
+	}
+	if b.APIKey, err = Resolve(b.APIKey); err != nil {
+		return b, fmt.Errorf("api key: %s", err)
+	}
+	if b.BearerToken, err = Resolve(b.BearerToken); err != nil {
+		return b, fmt.Errorf("bearer token: %s", err)
+	}
+	return b, nil
+}
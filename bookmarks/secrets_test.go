@@ -0,0 +1,52 @@
+package bookmarks
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Resolve(t *testing.T) {
+	os.Setenv("ESWEB_TEST_PASSWORD", "from-env")
+	defer os.Unsetenv("ESWEB_TEST_PASSWORD")
+
+	f, err := ioutil.TempFile("", "secret")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("from-file\n")
+	f.Close()
+
+	tests := map[string]string{
+		"plain":                   "plain",
+		"env:ESWEB_TEST_PASSWORD": "from-env",
+		"file:" + f.Name():        "from-file",
+		"cmd:echo from-cmd":       "from-cmd",
+	}
+	for ref, want := range tests {
+		got, err := Resolve(ref)
+		assert.NoError(t, err, ref)
+		assert.Equal(t, want, got, ref)
+	}
+
+	_, err = Resolve("env:ESWEB_TEST_MISSING")
+	assert.Error(t, err)
+
+	_, err = Resolve("cmd:false")
+	assert.Error(t, err)
+}
+
+func Test_CheckNoReferences(t *testing.T) {
+	assert.NoError(t, Bookmark{Addresses: []string{"http://localhost:9200"}, User: "elastic", Password: "secret"}.CheckNoReferences())
+	assert.Error(t, Bookmark{Password: "cmd:id"}.CheckNoReferences())
+	assert.Error(t, Bookmark{APIKey: " env:ES_API_KEY"}.CheckNoReferences())
+	assert.Error(t, Bookmark{ClientKey: "file:/etc/shadow"}.CheckNoReferences())
+	assert.Error(t, Bookmark{Addresses: []string{"file:/etc/hosts"}}.CheckNoReferences())
+}
+
+func Test_Public(t *testing.T) {
+	b := Bookmark{Password: "secret", APIKey: "env:ES_API_KEY"}.Public()
+	assert.Equal(t, "", b.Password)
+	assert.Equal(t, "env:ES_API_KEY", b.APIKey)
+}
@@ -0,0 +1,224 @@
+package client
+
+import "strings"
+
+// SQL words understood by the SQL editor, elasticsql supports this subset
+var SQLKeywords = []string{
+	"SELECT", "FROM", "WHERE", "AND", "OR", "NOT", "IN", "LIKE", "BETWEEN", "IS", "NULL",
+	"GROUP BY", "ORDER BY", "ASC", "DESC", "LIMIT", "AS",
+	"COUNT", "SUM", "AVG", "MIN", "MAX",
+	"date_histogram", "range", "date_range",
+}
+
+// Query clauses, valid inside query, filter, must, should, must_not and the like
+var dslQueries = []string{
+	"bool", "match", "match_all", "match_none", "match_phrase", "match_phrase_prefix", "multi_match",
+	"term", "terms", "range", "exists", "prefix", "wildcard", "regexp", "fuzzy", "ids",
+	"query_string", "simple_query_string", "nested", "has_child", "has_parent",
+	"constant_score", "function_score", "dis_max", "boosting", "script",
+	"geo_distance", "geo_bounding_box", "geo_shape", "more_like_this",
+}
+
+// Aggregation types, valid inside a named aggregation
+var dslAggregations = []string{
+	"terms", "date_histogram", "histogram", "range", "date_range", "filter", "filters",
+	"avg", "sum", "min", "max", "stats", "extended_stats", "cardinality", "value_count",
+	"percentiles", "percentile_ranks", "top_hits", "nested", "reverse_nested", "composite",
+	"significant_terms", "missing", "global", "aggs",
+}
+
+// Keys of objects which are not query clauses
+var dslKeys = map[string][]string{
+	"root": {
+		"query", "aggs", "size", "from", "sort", "_source", "highlight", "post_filter",
+		"track_total_hits", "search_after", "script_fields", "collapse", "timeout",
+		"min_score", "explain", "docvalue_fields", "stored_fields",
+	},
+	"bool":                {"must", "filter", "should", "must_not", "minimum_should_match", "boost"},
+	"constant_score":      {"filter", "boost"},
+	"function_score":      {"query", "functions", "score_mode", "boost_mode", "max_boost", "min_score", "field_value_factor", "script_score", "random_score"},
+	"dis_max":             {"queries", "tie_breaker"},
+	"boosting":            {"positive", "negative", "negative_boost"},
+	"nested":              {"path", "query", "score_mode", "inner_hits", "ignore_unmapped"},
+	"has_child":           {"type", "query", "score_mode", "min_children", "max_children", "inner_hits"},
+	"has_parent":          {"parent_type", "query", "score", "inner_hits"},
+	"multi_match":         {"query", "fields", "type", "operator", "fuzziness", "minimum_should_match", "tie_breaker", "analyzer"},
+	"query_string":        {"query", "default_field", "fields", "default_operator", "analyze_wildcard", "lenient", "time_zone"},
+	"simple_query_string": {"query", "fields", "default_operator", "flags", "analyze_wildcard"},
+	"terms":               {"boost"},
+	"exists":              {"field"},
+	"ids":                 {"values"},
+	"script":              {"script"},
+	"highlight":           {"fields", "pre_tags", "post_tags", "fragment_size", "number_of_fragments", "type", "require_field_match"},
+	"collapse":            {"field", "inner_hits", "max_concurrent_group_searches"},
+
+	// Options of a field of a field level query, such as range.<field>
+	"field:range":               {"gt", "gte", "lt", "lte", "format", "time_zone", "relation", "boost"},
+	"field:match":               {"query", "operator", "fuzziness", "analyzer", "minimum_should_match", "zero_terms_query", "boost"},
+	"field:match_phrase":        {"query", "slop", "analyzer", "boost"},
+	"field:match_phrase_prefix": {"query", "slop", "max_expansions", "analyzer"},
+	"field:term":                {"value", "boost"},
+	"field:prefix":              {"value", "boost", "rewrite"},
+	"field:wildcard":            {"value", "boost", "rewrite"},
+	"field:regexp":              {"value", "flags", "max_determinized_states", "boost"},
+	"field:fuzzy":               {"value", "fuzziness", "prefix_length", "max_expansions", "transpositions"},
+	"field:sort":                {"order", "mode", "missing", "unmapped_type", "nested"},
+	"field:highlight":           {"type", "fragment_size", "number_of_fragments", "pre_tags", "post_tags", "highlight_query"},
+
+	// Options of aggregations
+	"agg:terms":          {"field", "size", "order", "min_doc_count", "missing", "include", "exclude", "script", "shard_size"},
+	"agg:date_histogram": {"field", "interval", "calendar_interval", "fixed_interval", "format", "time_zone", "min_doc_count", "extended_bounds", "offset", "order"},
+	"agg:histogram":      {"field", "interval", "min_doc_count", "extended_bounds", "offset", "order"},
+	"agg:range":          {"field", "ranges", "keyed", "script"},
+	"agg:date_range":     {"field", "ranges", "format", "time_zone", "keyed"},
+	"agg:avg":            {"field", "missing", "script"},
+	"agg:sum":            {"field", "missing", "script"},
+	"agg:min":            {"field", "missing", "script"},
+	"agg:max":            {"field", "missing", "script"},
+	"agg:stats":          {"field", "missing", "script"},
+	"agg:extended_stats": {"field", "missing", "script", "sigma"},
+	"agg:cardinality":    {"field", "precision_threshold", "missing", "script"},
+	"agg:value_count":    {"field", "script"},
+	"agg:percentiles":    {"field", "percents", "keyed", "tdigest", "missing"},
+	"agg:top_hits":       {"size", "from", "sort", "_source", "highlight"},
+	"agg:nested":         {"path"},
+	"agg:reverse_nested": {"path"},
+	"agg:composite":      {"sources", "size", "after"},
+	"agg:missing":        {"field"},
+	"agg:filters":        {"filters", "other_bucket", "other_bucket_key"},
+}
+
+// Query clauses whose keys are field names, with the options of the field below them
+var dslFieldQueries = map[string]bool{
+	"range": true, "match": true, "match_phrase": true, "match_phrase_prefix": true,
+	"term": true, "prefix": true, "wildcard": true, "regexp": true, "fuzzy": true,
+	"terms": true, "geo_distance": true, "geo_bounding_box": true, "geo_shape": true,
+}
+
+// Keys which hold a query clause, or a list of them
+var dslQueryHolders = map[string]bool{
+	"query": true, "post_filter": true, "filter": true, "must": true, "should": true,
+	"must_not": true, "positive": true, "negative": true, "queries": true, "highlight_query": true,
+}
+
+// DSLCompletions returns the keys valid inside the object at path, the keys of its
+// enclosing objects from the root of a search body. fields reports whether field
+// names of the index are valid keys there too.
+func DSLCompletions(path []string) (keys []string, fields bool) {
+	state := "root"
+	for _, key := range path {
+		state = nextDSLState(state, key)
+	}
+
+	switch {
+	case state == "query":
+		return dslQueries, false
+	case state == "aggs":
+		// Keys are aggregation names chosen by the user
+		return []string{}, false
+	case state == "agg":
+		return dslAggregations, false
+	case state == "sort":
+		return []string{"_score", "_doc"}, true
+	case strings.HasPrefix(state, "fields:"):
+		return dslKeys[strings.TrimPrefix(state, "fields:")], true
+	}
+	return dslKeys[state], false
+}
+
+// nextDSLState returns the kind of object found under key in an object of kind state
+func nextDSLState(state, key string) string {
+	switch state {
+	case "root":
+		switch key {
+		case "query", "post_filter":
+			return "query"
+		case "aggs", "aggregations":
+			return "aggs"
+		case "sort":
+			return "sort"
+		case "highlight", "collapse":
+			return key
+		}
+	case "query":
+		if dslFieldQueries[key] {
+			return "fields:" + key
+		}
+		if _, ok := dslKeys[key]; ok {
+			return key
+		}
+	case "aggs":
+		return "agg"
+	case "agg":
+		switch key {
+		case "aggs", "aggregations":
+			return "aggs"
+		case "filter":
+			return "query"
+		}
+		return "agg:" + key
+	case "agg:top_hits":
+		return nextDSLState("root", key)
+	case "sort":
+		return "field:sort"
+	case "highlight":
+		if key == "fields" {
+			return "fields:"
+		}
+		if key == "highlight_query" {
+			return "query"
+		}
+	case "fields:":
+		return "field:highlight"
+	default:
+		if strings.HasPrefix(state, "fields:") {
+			return "field:" + strings.TrimPrefix(state, "fields:")
+		}
+	}
+
+	if dslQueryHolders[key] {
+		return "query"
+	}
+	return "unknown"
+}
+
+// FieldTypes returns the fields of the indices matching index with their types,
+// object fields are flattened to dotted names and multi-fields included
+func (c *Client) FieldTypes(index string) (map[string]string, error) {
+	res, err := c.Mapping(index)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for _, m := range res {
+		idx, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		flattenFields("", MappingProperties(idx["mappings"]), fields)
+	}
+	return fields, nil
+}
+
+func flattenFields(prefix string, props map[string]interface{}, out map[string]string) {
+	for name, v := range props {
+		def, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		typ, _ := def["type"].(string)
+		if sub, ok := def["properties"].(map[string]interface{}); ok {
+			if typ == "" {
+				typ = "object"
+			}
+			flattenFields(prefix+name+".", sub, out)
+		}
+		out[prefix+name] = typ
+
+		if multi, ok := def["fields"].(map[string]interface{}); ok {
+			flattenFields(prefix+name+".", multi, out)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DSLCompletions(t *testing.T) {
+	keys, fields := DSLCompletions(nil)
+	assert.Contains(t, keys, "query")
+	assert.Contains(t, keys, "aggs")
+	assert.False(t, fields)
+
+	keys, _ = DSLCompletions([]string{"query"})
+	assert.Contains(t, keys, "bool")
+	assert.Contains(t, keys, "range")
+
+	keys, fields = DSLCompletions([]string{"query", "bool"})
+	assert.Equal(t, []string{"must", "filter", "should", "must_not", "minimum_should_match", "boost"}, keys)
+	assert.False(t, fields)
+
+	keys, _ = DSLCompletions([]string{"query", "bool", "must"})
+	assert.Contains(t, keys, "match")
+
+	keys, fields = DSLCompletions([]string{"query", "bool", "must", "range"})
+	assert.True(t, fields)
+	assert.Empty(t, keys)
+
+	keys, fields = DSLCompletions([]string{"query", "bool", "filter", "range", "timestamp"})
+	assert.False(t, fields)
+	assert.Contains(t, keys, "lte")
+
+	keys, _ = DSLCompletions([]string{"aggs", "by_day"})
+	assert.Contains(t, keys, "date_histogram")
+
+	keys, _ = DSLCompletions([]string{"aggs", "by_day", "date_histogram"})
+	assert.Contains(t, keys, "interval")
+
+	keys, _ = DSLCompletions([]string{"aggs", "by_day", "aggs", "users"})
+	assert.Contains(t, keys, "cardinality")
+
+	keys, fields = DSLCompletions([]string{"sort"})
+	assert.True(t, fields)
+	assert.Contains(t, keys, "_score")
+
+	keys, _ = DSLCompletions([]string{"nothing", "here"})
+	assert.Empty(t, keys)
+}
+
+func Test_flattenFields(t *testing.T) {
+	props := map[string]interface{}{
+		"title": map[string]interface{}{
+			"type":   "text",
+			"fields": map[string]interface{}{"raw": map[string]interface{}{"type": "keyword"}},
+		},
+		"user": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{"type": "keyword"},
+			},
+		},
+		"tags": map[string]interface{}{
+			"type":       "nested",
+			"properties": map[string]interface{}{"value": map[string]interface{}{"type": "long"}},
+		},
+	}
+
+	fields := map[string]string{}
+	flattenFields("", props, fields)
+	assert.Equal(t, map[string]string{
+		"title":      "text",
+		"title.raw":  "keyword",
+		"user":       "object",
+		"user.name":  "keyword",
+		"tags":       "nested",
+		"tags.value": "long",
+	}, fields)
+}
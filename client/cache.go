@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+)
+
+var (
+	// CacheTTL is how long search results are reused, 0 disables the result cache
+	CacheTTL time.Duration
+	// CacheMaxBytes bounds the size of the cached responses, the least recently
+	// used ones are dropped past it
+	CacheMaxBytes int64 = 64 << 20
+)
+
+// CacheInfo tells whether a result came from the result cache and its age
+type CacheInfo struct {
+	Hit bool
+	Age time.Duration
+}
+
+type cacheEntry struct {
+	key     string
+	cluster string
+	index   string
+	body    []byte
+	stored  time.Time
+}
+
+// resultCache keeps raw search responses by cluster, index and search body
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+var results = newResultCache()
+
+func newResultCache() *resultCache {
+	return &resultCache{entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (rc *resultCache) get(key string) ([]byte, time.Duration, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	el, ok := rc.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	e := el.Value.(*cacheEntry)
+	age := time.Since(e.stored)
+	if age > CacheTTL {
+		rc.remove(el)
+		return nil, 0, false
+	}
+	rc.lru.MoveToFront(el)
+	return e.body, age, true
+}
+
+func (rc *resultCache) put(e *cacheEntry) {
+	if int64(len(e.body)) > CacheMaxBytes {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if el, ok := rc.entries[e.key]; ok {
+		rc.remove(el)
+	}
+	rc.entries[e.key] = rc.lru.PushFront(e)
+	rc.size += int64(len(e.body))
+
+	for rc.size > CacheMaxBytes {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// invalidate drops the results of a cluster which may read from index
+func (rc *resultCache) invalidate(cluster, index string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for el := rc.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*cacheEntry)
+		if e.cluster == cluster && indicesOverlap(e.index, index) {
+			rc.remove(el)
+		}
+		el = next
+	}
+}
+
+func (rc *resultCache) remove(el *list.Element) {
+	e := rc.lru.Remove(el).(*cacheEntry)
+	delete(rc.entries, e.key)
+	rc.size -= int64(len(e.body))
+}
+
+// indicesOverlap reports whether two index expressions may name a common index.
+// Aliases are not resolved, their results stay until the TTL.
+func indicesOverlap(a, b string) bool {
+	for _, x := range strings.Split(a, ",") {
+		x = strings.TrimSpace(x)
+		if x == "" || x == "_all" || x == "*" {
+			return true
+		}
+		for _, y := range strings.Split(b, ",") {
+			y = strings.TrimSpace(y)
+			if y == "" || y == "_all" || x == y {
+				return true
+			}
+			if m, _ := path.Match(x, y); m {
+				return true
+			}
+			if m, _ := path.Match(y, x); m {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeDSL formats a search body so that equal searches have the same text
+func normalizeDSL(body string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		return strings.TrimSpace(body)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// BypassCache returns a copy of the client whose searches go to the cluster, their
+// results still refresh the cache
+func (c *Client) BypassCache() *Client {
+	s := *c
+	s.noCache = true
+	return &s
+}
+
+// InvalidateCache drops the cached results which may read from index
+func (c *Client) InvalidateCache(index string) {
+	results.invalidate(c.cluster, index)
+}
+
+// cachedSearch runs a search and returns the raw response with its status code. The
+// variant tells apart the searches sent with different options.
+func (c *Client) cachedSearch(variant, index, body string, opts ...func(*esapi.SearchRequest)) ([]byte, int, CacheInfo, error) {
+	enabled := CacheTTL > 0
+	key := strings.Join([]string{c.cluster, variant, index, normalizeDSL(body)}, "\x00")
+
+	if enabled && !c.noCache {
+		if b, age, ok := results.get(key); ok {
+			return b, 200, CacheInfo{Hit: true, Age: age}, nil
+		}
+	}
+
+	opts = append([]func(*esapi.SearchRequest){
+		c.es.Search.WithIndex(index),
+		c.es.Search.WithBody(strings.NewReader(body)),
+		c.es.Search.WithTimeout(c.searchTimeout()),
+	}, opts...)
+	start := time.Now()
+	res, err := c.es.Search(opts...)
+	if err != nil {
+		return nil, 0, CacheInfo{}, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, res.StatusCode, CacheInfo{}, err
+	}
+	roundTrip := time.Since(start)
+
+	var r struct {
+		Took     int64       `json:"took"`
+		TimedOut bool        `json:"timed_out"`
+		Shards   ShardCounts `json:"_shards"`
+		Hits     struct {
+			Total hitsTotal `json:"total"`
+		} `json:"hits"`
+	}
+	if res.IsError() || json.Unmarshal(b, &r) != nil {
+		return b, res.StatusCode, CacheInfo{}, nil
+	}
+
+	recordQuery(QueryRecord{
+		Cluster:   c.Alias,
+		Index:     index,
+		Query:     strings.TrimSpace(body),
+		Took:      r.Took,
+		RoundTrip: int64(roundTrip / time.Millisecond),
+		Shards:    r.Shards,
+		Hits:      int64(r.Hits.Total),
+		TimedOut:  r.TimedOut,
+	})
+
+	// Partial results of timed out searches are not kept
+	if enabled && !r.TimedOut {
+		results.put(&cacheEntry{key: key, cluster: c.cluster, index: index, body: b, stored: time.Now()})
+	}
+	return b, res.StatusCode, CacheInfo{}, nil
+}
+
+// checkStatus returns the error of a raw response read by cachedSearch
+func checkStatus(b []byte, status int) error {
+	return checkElasticResp(&esapi.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewReader(b))}, nil)
+}
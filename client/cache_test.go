@@ -0,0 +1,105 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_indicesOverlap(t *testing.T) {
+	assert.True(t, indicesOverlap("logs", "logs"))
+	assert.True(t, indicesOverlap("logs-*", "logs-2024"))
+	assert.True(t, indicesOverlap("logs-2024", "logs-*"))
+	assert.True(t, indicesOverlap("users,logs-2024", "logs-2024"))
+	assert.True(t, indicesOverlap("", "logs"))
+	assert.True(t, indicesOverlap("_all", "logs"))
+	assert.False(t, indicesOverlap("users", "logs"))
+	assert.False(t, indicesOverlap("logs-2023", "logs-2024"))
+}
+
+func Test_resultCache(t *testing.T) {
+	defer func(ttl time.Duration, max int64) { CacheTTL, CacheMaxBytes = ttl, max }(CacheTTL, CacheMaxBytes)
+	CacheTTL, CacheMaxBytes = time.Minute, 10
+
+	rc := newResultCache()
+	rc.put(&cacheEntry{key: "a", cluster: "c", index: "logs", body: []byte("aaaa"), stored: time.Now()})
+	rc.put(&cacheEntry{key: "b", cluster: "c", index: "users", body: []byte("bbbb"), stored: time.Now()})
+	_, _, ok := rc.get("a")
+	assert.True(t, ok)
+
+	// b is the least recently used
+	rc.put(&cacheEntry{key: "c", cluster: "c", index: "logs", body: []byte("cccc"), stored: time.Now()})
+	_, _, ok = rc.get("b")
+	assert.False(t, ok)
+	assert.Equal(t, int64(8), rc.size)
+
+	rc.put(&cacheEntry{key: "big", cluster: "c", body: []byte("too large for the cache"), stored: time.Now()})
+	_, _, ok = rc.get("big")
+	assert.False(t, ok)
+
+	rc.put(&cacheEntry{key: "old", cluster: "c", body: []byte("o"), stored: time.Now().Add(-time.Hour)})
+	_, _, ok = rc.get("old")
+	assert.False(t, ok)
+
+	rc.invalidate("other", "logs")
+	_, _, ok = rc.get("a")
+	assert.True(t, ok)
+	rc.invalidate("c", "logs")
+	_, _, ok = rc.get("a")
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), rc.size)
+}
+
+func Test_cachedSearch(t *testing.T) {
+	defer func(ttl time.Duration) { CacheTTL = ttl }(CacheTTL)
+	CacheTTL = time.Minute
+
+	searches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"6.8.5"}}`))
+		case "/cached/_search":
+			searches++
+			w.Write([]byte(`{"timed_out":false,"hits":{"total":1,"hits":[{"_id":"1","_source":{"a":1}}]}}`))
+		case "/cached/_refresh":
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+
+	res, err := cl.Search("cached", `{"query": {"match_all": {}}}`)
+	assert.NoError(t, err)
+	assert.False(t, res.Cache().Hit)
+
+	res, err = cl.Search("cached", `{"query":{"match_all":{}}}`)
+	assert.NoError(t, err)
+	assert.True(t, res.Cache().Hit)
+	assert.Equal(t, 1, searches)
+
+	_, _, err = cl.SearchWithBody("cached", `{"query":{"match_all":{}}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, searches)
+
+	res, err = cl.BypassCache().Search("cached", `{"query":{"match_all":{}}}`)
+	assert.NoError(t, err)
+	assert.False(t, res.Cache().Hit)
+	assert.Equal(t, 3, searches)
+
+	assert.NoError(t, cl.ManageIndex("cached", "refresh"))
+	res, err = cl.Search("cached", `{"query":{"match_all":{}}}`)
+	assert.NoError(t, err)
+	assert.False(t, res.Cache().Hit)
+	assert.Equal(t, 4, searches)
+}
@@ -2,7 +2,6 @@ package client
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/elastic/go-elasticsearch/v6"
@@ -13,6 +12,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 )
 
 var (
@@ -27,9 +27,17 @@ var History = make(map[string][]Record)
 type Client struct {
 	es            *elasticsearch.Client
 	serverVersion string
+	distribution  string
 	clusterName   string
 	Alias         string
 	KibanaUrl     string
+	Protected     []string // Index patterns which cannot be deleted, closed or frozen
+	Roles         []string // Roles of the esweb users who may use the connection, everybody when empty
+
+	cluster string        // Identifies the connection in the result cache
+	timeout time.Duration // Timeout of searches, DefaultTimeout when 0
+	noCache bool          // Searches skip the result cache
+	sql     *sqlSupport   // Shared by the copies of WithContext
 }
 
 func New() (*Client, error) {
@@ -37,36 +45,30 @@ func New() (*Client, error) {
 }
 
 func NewFromParams(host, alias, user, password string) (*Client, error) {
-	cfg := elasticsearch.Config{}
-	cfg.Addresses = []string{host}
-
-	if user != "" && password != "" {
-		cfg.Username = user
-	}
 	password, _ = url.QueryUnescape(password)
-	cfg.Password = password
+	return NewFromConfig(bookmarks.Bookmark{
+		Addresses: []string{host},
+		User:      user,
+		Password:  password,
+		Alias:     alias,
+	})
+}
 
-	client, err := elasticsearch.NewClient(cfg)
+// NewFromBookmarks connects to a bookmarked cluster, resolving the secret references of the bookmark
+func NewFromBookmarks(conf bookmarks.Bookmark) (*Client, error) {
+	conf, err := conf.Resolved()
 	if err != nil {
 		return nil, err
 	}
-
-	s := Client{
-		es:    client,
-		Alias: alias,
-	}
-	s.SetServerVersion()
-	return &s, nil
+	return NewFromConfig(conf)
 }
 
-func NewFromBookmarks(conf bookmarks.Bookmark) (*Client, error) {
-	cfg := elasticsearch.Config{
-		Addresses: conf.Addresses,
-	}
-
-	if conf.User != "" && conf.Password != "" {
-		cfg.Username = conf.User
-		cfg.Password = conf.Password
+// NewFromConfig connects with the connection options as given, secret references are
+// not resolved so it is safe for options coming from the connect form
+func NewFromConfig(conf bookmarks.Bookmark) (*Client, error) {
+	cfg, err := esConfig(conf)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := elasticsearch.NewClient(cfg)
@@ -75,20 +77,32 @@ func NewFromBookmarks(conf bookmarks.Bookmark) (*Client, error) {
 	}
 
 	s := Client{
-		es:    client,
-		Alias: conf.Alias,
+		es:        client,
+		Alias:     conf.Alias,
+		Protected: conf.Protected,
+		Roles:     conf.Roles,
+		cluster:   strings.Join([]string{strings.Join(conf.Addresses, ","), conf.CloudID, conf.User}, "|"),
+		sql:       &sqlSupport{},
 	}
 	s.SetServerVersion()
 	return &s, nil
 }
 
 func (c *Client) Alive() bool {
+	return c.Ping() == nil
+}
+
+// Ping checks the cluster can be reached, TLS failures are explained
+func (c *Client) Ping() error {
 	res, err := c.es.Ping()
-	if err := checkElasticResp(res, err); err != nil {
-		return false
+	if err != nil {
+		return describeConnError(err)
 	}
 	defer res.Body.Close()
-	return true
+	if res.IsError() {
+		return fmt.Errorf("%s", res.Status())
+	}
+	return nil
 }
 
 func (c *Client) ClusterName() (string, error) {
@@ -112,6 +126,9 @@ func (c *Client) ClusterName() (string, error) {
 
 func (c *Client) Info() (map[string]interface{}, error) {
 	res, err := c.es.Info()
+	if err != nil {
+		return nil, describeConnError(err)
+	}
 
 	if err := checkElasticResp(res, err); err != nil {
 		return nil, err
@@ -120,10 +137,14 @@ func (c *Client) Info() (map[string]interface{}, error) {
 
 	var r map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		log.Fatalf("Error parsing the response body: %c", err)
+		return nil, fmt.Errorf("not an Elasticsearch response: %s", err)
+	}
+	version, ok := r["version"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not an Elasticsearch response: version missing")
 	}
 	r["alias"] = c.Alias
-	for k, v := range r["version"].(map[string]interface{}) {
+	for k, v := range version {
 		r["version."+k] = v
 	}
 	delete(r, "version")
@@ -133,7 +154,8 @@ func (c *Client) Info() (map[string]interface{}, error) {
 func (c *Client) SetServerVersion() {
 	i, err := c.Info()
 	if err == nil {
-		c.serverVersion = i["version.number"].(string)
+		c.serverVersion, _ = i["version.number"].(string)
+		c.distribution, _ = i["version.distribution"].(string)
 	}
 }
 
@@ -272,6 +294,10 @@ func (c *Client) ManageIndex(index string, action string) error {
 		res *esapi.Response
 	)
 
+	if err := c.CheckProtected(index, action); err != nil {
+		return err
+	}
+
 	switch action {
 	case "delete":
 		res, err = c.es.Indices.Delete([]string{index})
@@ -284,11 +310,16 @@ func (c *Client) ManageIndex(index string, action string) error {
 	case "clear_cache":
 		res, err = c.es.Indices.ClearCache(c.es.Indices.ClearCache.WithIndex(index))
 	case "freeze":
+		if c.IsOpenSearch() || c.MajorVersion() >= 8 {
+			return fmt.Errorf("freezing indices is not supported by %s", c.Product())
+		}
 		res, err = c.es.Indices.Freeze(index)
 	case "close":
 		res, err = c.es.Indices.Close([]string{index})
 	case "open":
 		res, err = c.es.Indices.Open([]string{index})
+	default:
+		return fmt.Errorf("unknown index action %q", action)
 	}
 
 	if err := checkElasticResp(res, err); err != nil {
@@ -296,6 +327,7 @@ func (c *Client) ManageIndex(index string, action string) error {
 	}
 	defer res.Body.Close()
 
+	c.InvalidateCache(index)
 	return nil
 
 }
@@ -305,11 +337,12 @@ func (c *Client) QueryRows(indexName string, opts RowsOptions) (*searchResponse,
 
 	// Perform the search request.
 	res, err := c.es.Search(
-		c.es.Search.WithContext(context.Background()),
+		c.es.Search.WithTimeout(c.searchTimeout()),
 		c.es.Search.WithIndex(indexName),
 		c.es.Search.WithBody(opts.buildRowsQuery()),
 		c.es.Search.WithFrom(opts.Offset),
 		c.es.Search.WithSize(opts.Limit),
+		c.es.Search.WithTrackTotalHits(true),
 	)
 
 	if err := checkElasticResp(res, err); err != nil {
@@ -324,73 +357,135 @@ func (c *Client) QueryRows(indexName string, opts RowsOptions) (*searchResponse,
 	return &r, nil
 }
 
-func (c *Client) Query(sql string) (*searchResponse, error) {
-	dsl, index, err := c.GetDsl(sql)
+// Search runs a search for a result table, repeated searches may come from the result cache
+func (c *Client) Search(indexName string, body string) (*searchResponse, error) {
+	var r searchResponse
 
+	b, status, cache, err := c.cachedSearch("table", indexName, body,
+		c.es.Search.WithTrackTotalHits(true),
+		c.es.Search.WithPretty(),
+	)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkStatus(b, status); err != nil {
+		return nil, err
+	}
 
-	if !c.hasHistoryRecord(sql) {
-		History[c.Alias] = append(History[c.Alias], newHistoryRecord(sql))
+	if err := json.Unmarshal(b, &r); err != nil {
+		log.Printf("Error parsing the response body: %c", err)
+		return nil, err
 	}
+	r.cache = cache
 
-	return c.Search(index, dsl)
+	return &r, nil
 }
 
-func (c *Client) Search(indexName string, body string) (*searchResponse, error) {
-	var r searchResponse
+// SearchWithBody runs a search and returns the response as it is, errors of the
+// cluster included
+func (c *Client) SearchWithBody(index, body string) (map[string]interface{}, CacheInfo, error) {
+	b, _, cache, err := c.cachedSearch("raw", index, body)
+	if err != nil {
+		return nil, cache, err
+	}
 
-	// Build the request body.
-	var buf bytes.Buffer
-	buf.WriteString(body)
+	var r map[string]interface{}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, cache, fmt.Errorf("error parsing the response body: %s", err)
+	}
+
+	if !c.hasHistoryRecord(body) {
+		History[c.Alias] = append(History[c.Alias], newHistoryRecord(body))
+	}
+	return r, cache, nil
+}
+
+// searchBody runs a search with a body built from a map
+func (c *Client) searchBody(indexName string, body map[string]interface{}) (*searchResponse, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
 
-	// Perform the search request.
 	res, err := c.es.Search(
-		c.es.Search.WithContext(context.Background()),
 		c.es.Search.WithIndex(indexName),
-		c.es.Search.WithBody(&buf),
-		c.es.Search.WithTrackTotalHits(true),
-		c.es.Search.WithPretty(),
+		c.es.Search.WithBody(bytes.NewReader(b)),
 	)
-
 	if err := checkElasticResp(res, err); err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
+	var r searchResponse
 	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		log.Printf("Error parsing the response body: %c", err)
 		return nil, err
 	}
-
 	return &r, nil
 }
 
-func (c *Client) SearchWithBody(index, body string) (map[string]interface{}, error) {
-	var buf bytes.Buffer
-	buf.WriteString(body)
+// scroll pages through every document matching the search body and calls fn for each page
+func (c *Client) scroll(indexName string, body map[string]interface{}, size int, keepAlive time.Duration, fn func(*searchResponse) error) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
 
-	// Perform the search request.
 	res, err := c.es.Search(
-		c.es.Search.WithContext(context.Background()),
-		c.es.Search.WithIndex(index),
-		c.es.Search.WithBody(&buf),
+		c.es.Search.WithIndex(indexName),
+		c.es.Search.WithBody(bytes.NewReader(b)),
+		c.es.Search.WithSort("_doc"),
+		c.es.Search.WithSize(size),
+		c.es.Search.WithScroll(keepAlive),
 	)
+
+	for {
+		if err := checkElasticResp(res, err); err != nil {
+			return err
+		}
+
+		var r searchResponse
+		err = json.NewDecoder(res.Body).Decode(&r)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if r.IsEmpty() {
+			c.clearScroll(r.ScrollID)
+			return nil
+		}
+
+		if err := fn(&r); err != nil {
+			c.clearScroll(r.ScrollID)
+			return err
+		}
+
+		res, err = c.nextScroll(r.ScrollID, keepAlive)
+	}
+}
+
+// nextScroll fetches the next page of a scroll. The scroll id goes in the body, the
+// URL forms are deprecated from 7.x on and scroll ids can outgrow the URL length limit.
+func (c *Client) nextScroll(scrollID string, keepAlive time.Duration) (*esapi.Response, error) {
+	b, err := json.Marshal(map[string]interface{}{
+		"scroll":    keepAlive.String(),
+		"scroll_id": scrollID,
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
+	return c.es.Scroll(c.es.Scroll.WithBody(bytes.NewReader(b)))
+}
 
-	var r map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		log.Fatalf("Error parsing the response body: %c", err)
+func (c *Client) clearScroll(scrollID string) {
+	if scrollID == "" {
+		return
 	}
-
-	if !c.hasHistoryRecord(body) {
-		History[c.Alias] = append(History[c.Alias], newHistoryRecord(body))
+	b, _ := json.Marshal(map[string]interface{}{"scroll_id": []string{scrollID}})
+	res, err := c.es.ClearScroll(c.es.ClearScroll.WithBody(bytes.NewReader(b)))
+	if err == nil {
+		res.Body.Close()
 	}
-	return r, nil
 }
 
 func (c *Client) Export(indexName string, body string) (*searchResponse, error) {
@@ -405,7 +500,7 @@ func (c *Client) Export(indexName string, body string) (*searchResponse, error)
 
 	// Perform the search request.
 	res, err := c.es.Search(
-		c.es.Search.WithContext(context.Background()),
+		c.es.Search.WithTimeout(c.searchTimeout()),
 		c.es.Search.WithIndex(indexName),
 		c.es.Search.WithBody(&buf),
 		c.es.Search.WithTrackTotalHits(true),
@@ -437,18 +532,6 @@ func (c *Client) hasHistoryRecord(query string) bool {
 	return result
 }
 
-func (c *Client) GetDsl(sql string) (dsl, index string, err error) {
-	dsl, index, err = elasticsql.Convert(sql)
-
-	if err != nil {
-		dsl, index, err = convertRetry(sql)
-		if err != nil {
-			return
-		}
-	}
-	return
-}
-
 func convertRetry(sql string) (dsl, index string, err error) {
 	var regTable = regexp.MustCompile("(?i)" + `FROM\s+(?P<table>[^ ,]+)|FROM\s+(?P<table>[^ ,]+)(?:\s*,\s*([^ ,]+))*\s+`)
 	matches := regTable.FindStringSubmatch(sql)
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6"
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/elastic/go-elasticsearch/v6/estransport"
+)
+
+// DefaultTimeout limits the searches which have no timeout of their own, 0 for none
+var DefaultTimeout time.Duration
+
+// Actions of the search tasks a query runs, X-Pack SQL included
+const searchTaskActions = "indices:data/read/*"
+
+// scopedTransport binds the requests of a client to a context, tagged with the
+// X-Opaque-Id of the query they belong to so that its tasks can be found
+type scopedTransport struct {
+	ctx     context.Context
+	queryID string
+	next    estransport.Interface
+}
+
+func (t *scopedTransport) Perform(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(t.ctx)
+	if t.queryID != "" {
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		req.Header.Set("X-Opaque-Id", t.queryID)
+	}
+	return t.next.Perform(req)
+}
+
+// WithContext returns a copy of the client whose requests stop when ctx is done. With
+// a query id its searches can be cancelled with CancelQuery, timeout overrides
+// DefaultTimeout when it is not 0.
+func (c *Client) WithContext(ctx context.Context, queryID string, timeout time.Duration) *Client {
+	t := &scopedTransport{ctx: ctx, queryID: queryID, next: c.es.Transport}
+
+	s := *c
+	s.es = &elasticsearch.Client{Transport: t, API: esapi.New(t)}
+	if timeout > 0 {
+		s.timeout = timeout
+	}
+	return &s
+}
+
+// searchTimeout is the timeout parameter of the searches of the client
+func (c *Client) searchTimeout() time.Duration {
+	if c.timeout > 0 {
+		return c.timeout
+	}
+	return DefaultTimeout
+}
+
+// CancelQuery cancels the search tasks of a query started by a client of
+// WithContext, and returns how many were cancelled
+func (c *Client) CancelQuery(queryID string) (int, error) {
+	res, err := c.es.Tasks.List(
+		c.es.Tasks.List.WithActions(searchTaskActions),
+		c.es.Tasks.List.WithDetailed(true),
+	)
+	if err := checkElasticResp(res, err); err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	var r struct {
+		Nodes map[string]struct {
+			Tasks map[string]struct {
+				Headers map[string]string `json:"headers"`
+			} `json:"tasks"`
+		} `json:"nodes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, err
+	}
+
+	var ids []string
+	for _, node := range r.Nodes {
+		for id, task := range node.Tasks {
+			for k, v := range task.Headers {
+				if strings.EqualFold(k, "X-Opaque-Id") && v == queryID {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+
+	// Tasks may finish before they are cancelled
+	cancelled := 0
+	for _, id := range ids {
+		res, err := c.es.Tasks.Cancel(c.es.Tasks.Cancel.WithTaskID(id))
+		if err := checkElasticResp(res, err); err != nil {
+			continue
+		}
+		res.Body.Close()
+		cancelled++
+	}
+	return cancelled, nil
+}
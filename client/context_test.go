@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WithContext(t *testing.T) {
+	var timeout, opaqueID, cancelled string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"6.8.5"}}`))
+		case "/logs/_search":
+			timeout, opaqueID = r.URL.Query().Get("timeout"), r.Header.Get("X-Opaque-Id")
+			w.Write([]byte(`{"hits":{"total":0,"hits":[]}}`))
+		case "/_search/scroll":
+			w.Write([]byte(`{"hits":{"total":0,"hits":[]}}`))
+		case "/_tasks":
+			w.Write([]byte(`{"nodes":{"n1":{"tasks":{
+				"n1:1":{"action":"indices:data/read/search","headers":{"X-Opaque-Id":"q1"}},
+				"n1:2":{"action":"indices:data/read/search","headers":{"X-Opaque-Id":"q2"}},
+				"n1:3":{"action":"indices:data/read/search","headers":{}}}}}}`))
+		case "/_tasks/n1:1/_cancel":
+			cancelled = "n1:1"
+			w.Write([]byte(`{"nodes":{}}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+
+	defer func(d time.Duration) { DefaultTimeout = d }(DefaultTimeout)
+	DefaultTimeout = time.Minute
+
+	_, err = cl.Search("logs", `{}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "60000ms", timeout)
+	assert.Equal(t, "", opaqueID)
+
+	_, err = cl.WithContext(context.Background(), "q1", 5*time.Second).Search("logs", `{}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "5000ms", timeout)
+	assert.Equal(t, "q1", opaqueID)
+
+	timeout = ""
+	mc := MigrateConfig{SrcIndexName: "logs"}
+	assert.NoError(t, mc.Export(cl.WithContext(context.Background(), "q1", 5*time.Second), ioutil.Discard))
+	assert.Equal(t, "5000ms", timeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cl.WithContext(ctx, "", 0).Search("logs", `{}`)
+	assert.Error(t, err)
+
+	n, err := cl.CancelQuery("q1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "n1:1", cancelled)
+}
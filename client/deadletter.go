@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// DeadLetter is a document which could not be migrated, one line of a dead-letter file
+type DeadLetter struct {
+	ID       string                 `json:"_id"`
+	Index    string                 `json:"_index"`
+	DocType  string                 `json:"_type,omitempty"`
+	Status   int                    `json:"status,omitempty"`
+	Type     string                 `json:"error_type"`
+	Reason   string                 `json:"reason"`
+	Attempts int                    `json:"attempts"`
+	Time     time.Time              `json:"time"`
+	Source   map[string]interface{} `json:"_source"`
+}
+
+// DeadLetterDir returns the directory of the dead-letter files
+func DeadLetterDir() string {
+	return filepath.Join(DataDir, deadLetterKind)
+}
+
+// DeadLetterPath returns the path of a dead-letter file by name
+func DeadLetterPath(name string) (string, error) {
+	return dataFilePath(deadLetterKind, name)
+}
+
+// ListDeadLetterFiles returns the dead-letter files, newest first
+func ListDeadLetterFiles() ([]DataFile, error) {
+	return listDataFiles(deadLetterKind)
+}
+
+// deadLetter records a document which could not be migrated
+func (mc *MigrateConfig) deadLetter(f bulkFailure) {
+	atomic.AddInt64(&mc.NumFailed, 1)
+
+	err := mc.dead.Write(DeadLetter{
+		ID:       f.doc.ID,
+		Index:    mc.DstIndexName,
+		DocType:  f.doc.Type,
+		Status:   f.status,
+		Type:     f.typ,
+		Reason:   f.reason,
+		Attempts: f.doc.Attempts,
+		Time:     time.Now(),
+		Source:   f.doc.Source,
+	})
+	if err != nil {
+		log.Printf("Cannot write document %s to dead-letter file %s: %s", f.doc.ID, mc.DeadLetterFile, err)
+	}
+}
+
+// Replay migrates the documents of a dead-letter file again. The transform and the
+// destination mode are applied as in a migration, documents failing again are
+// written to a new dead-letter file. The destination index defaults to the index of
+// the file, it cannot be overwritten by a replay.
+func (mc *MigrateConfig) Replay(path string) error {
+	if mc.DstMode == DstModeOverwrite {
+		return errors.New("a dead-letter file cannot be replayed in overwrite mode")
+	}
+	if err := mc.Transform.Validate(); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	next := func() (*DeadLetter, error) {
+		if !scanner.Scan() {
+			return nil, scanner.Err()
+		}
+		var dl DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &dl); err != nil {
+			return nil, fmt.Errorf("invalid dead-letter file %s: %s", path, err)
+		}
+		return &dl, nil
+	}
+
+	dl, err := next()
+	if err != nil {
+		return err
+	}
+	if dl == nil {
+		return fmt.Errorf("dead-letter file %s is empty", path)
+	}
+	if mc.DstIndexName == "" {
+		mc.DstIndexName = dl.Index
+	}
+	if err := mc.validateDst(); err != nil {
+		return err
+	}
+
+	mc.start()
+	defer mc.dead.Close()
+	if err := mc.setDocType(); err != nil {
+		return err
+	}
+
+	var docs []bulkDoc
+	for dl != nil {
+		atomic.AddInt64(&mc.total, 1)
+
+		doc, err := mc.prepare(dl.ID, dl.DocType, dl.Source)
+		if err != nil {
+			mc.deadLetter(bulkFailure{doc: bulkDoc{ID: dl.ID, Type: dl.DocType, Source: dl.Source}, typ: "transform", reason: err.Error()})
+		} else {
+			docs = append(docs, doc)
+		}
+		if len(docs) >= mc.throttle.Batch() {
+			mc.flush(docs)
+			docs = nil
+		}
+
+		if dl, err = next(); err != nil {
+			return err
+		}
+	}
+
+	if len(docs) > 0 {
+		mc.flush(docs)
+	}
+	return mc.dead.Close()
+}
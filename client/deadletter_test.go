@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_deadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esweb")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	DataDir = dir
+
+	mc := MigrateConfig{DstIndexName: "articles/copy"}
+	mc.start()
+	assert.Equal(t, DeadLetterDir(), filepath.Dir(mc.DeadLetterFile))
+
+	mc.deadLetter(bulkFailure{
+		doc:    bulkDoc{ID: "1", Source: map[string]interface{}{"a": "b"}, Attempts: 2},
+		status: 400,
+		typ:    "mapper_parsing_exception",
+		reason: "failed to parse",
+	})
+	assert.Nil(t, mc.dead.Close())
+	assert.Equal(t, int64(1), mc.Stats().Failed)
+
+	files, err := ListDeadLetterFiles()
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+
+	path, err := DeadLetterPath(files[0].Name)
+	assert.Nil(t, err)
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	assert.True(t, scanner.Scan())
+	var dl DeadLetter
+	assert.Nil(t, json.Unmarshal(scanner.Bytes(), &dl))
+	assert.Equal(t, "1", dl.ID)
+	assert.Equal(t, "articles/copy", dl.Index)
+	assert.Equal(t, "mapper_parsing_exception", dl.Type)
+	assert.Equal(t, map[string]interface{}{"a": "b"}, dl.Source)
+
+	_, err = DeadLetterPath("../secret.ndjson")
+	assert.NotNil(t, err)
+}
+
+func Test_isTransient(t *testing.T) {
+	assert.True(t, isTransient(429, ""))
+	assert.True(t, isTransient(503, ""))
+	assert.True(t, isTransient(400, "es_rejected_execution_exception"))
+	assert.False(t, isTransient(400, "mapper_parsing_exception"))
+}
+
+func Test_Replay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esweb")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	DataDir = dir
+
+	path := filepath.Join(dir, "failed.ndjson")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"_id":"1","_index":"copy","error_type":"x","_source":{"a":1}}`+"\n"), 0600))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+		case "/copy/_bulk":
+			w.Write([]byte(`{"errors":false,"items":[{"index":{"_id":"1","status":201}}]}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	dst, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.Nil(t, err)
+
+	mc := MigrateConfig{DstEs: dst, DstMode: DstModeOverwrite, Confirm: "copy"}
+	assert.NotNil(t, mc.Replay(path))
+
+	mc = MigrateConfig{DstEs: dst, DstMode: DstModeAppend}
+	assert.Nil(t, mc.Replay(path))
+	assert.Equal(t, "copy", mc.DstIndexName)
+	assert.Equal(t, int64(1), mc.Stats().Bulked)
+}
@@ -0,0 +1,293 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Destination modes, they decide what happens when the destination index already exists
+const (
+	DstModeFail      = "fail"      // Refuse to migrate into an existing index
+	DstModeAppend    = "append"    // Add documents, existing documents with the same _id are kept
+	DstModeOverwrite = "overwrite" // Delete and create the index again, needs Confirm
+	DstModeUpsert    = "upsert"    // Insert new documents and update existing ones by _id
+)
+
+// Mapping modes, they decide which mappings and settings a (re)created destination index gets
+const (
+	MappingsSource      = "source"      // Copy mappings and settings of the source index
+	MappingsDestination = "destination" // Keep the destination's own mappings and settings
+)
+
+// Field types which accept each other's values
+var compatibleTypes = map[string]string{
+	"long":         "number",
+	"integer":      "number",
+	"short":        "number",
+	"byte":         "number",
+	"double":       "number",
+	"float":        "number",
+	"half_float":   "number",
+	"scaled_float": "number",
+	"text":         "string",
+	"keyword":      "string",
+}
+
+func (mc *MigrateConfig) validateDst() error {
+	switch mc.DstMode {
+	case "":
+		mc.DstMode = DstModeFail
+	case DstModeFail, DstModeAppend, DstModeOverwrite, DstModeUpsert:
+	default:
+		return fmt.Errorf("unknown destination mode %q", mc.DstMode)
+	}
+
+	switch mc.DstMappings {
+	case "":
+		mc.DstMappings = MappingsSource
+	case MappingsSource, MappingsDestination:
+	default:
+		return fmt.Errorf("unknown mappings mode %q", mc.DstMappings)
+	}
+
+	if mc.DstMode == DstModeOverwrite {
+		if mc.Confirm != mc.DstIndexName {
+			return fmt.Errorf("overwriting %s must be confirmed by repeating the index name", mc.DstIndexName)
+		}
+		if mc.DstEs != nil {
+			if err := mc.DstEs.CheckProtected(mc.DstIndexName, "delete"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CreateDstIndex prepares the destination index according to DstMode and DstMappings
+func (mc *MigrateConfig) CreateDstIndex() error {
+	if err := mc.validateDst(); err != nil {
+		return err
+	}
+
+	res, err := mc.DstEs.es.Indices.Exists([]string{mc.DstIndexName})
+	if err != nil {
+		return fmt.Errorf("error check exisits: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 200 {
+		body := map[string]interface{}{}
+		if mc.DstMappings == MappingsSource {
+			if body, err = mc.dstIndexBody(); err != nil {
+				return fmt.Errorf("error get index settings: %s", err)
+			}
+		}
+		return mc.createIndex(body)
+	}
+
+	switch mc.DstMode {
+	case DstModeAppend, DstModeUpsert:
+		conflicts, err := mc.CheckMappings()
+		if err != nil {
+			return fmt.Errorf("error check mappings: %s", err)
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("mappings of %s are not compatible: %s", mc.DstIndexName, strings.Join(conflicts, "; "))
+		}
+		return nil
+	case DstModeOverwrite:
+		var body map[string]interface{}
+		if mc.DstMappings == MappingsSource {
+			body, err = mc.dstIndexBody()
+		} else {
+			body, err = indexBody(mc.DstEs, mc.DstIndexName)
+		}
+		if err != nil {
+			return fmt.Errorf("error get index settings: %s", err)
+		}
+
+		if err := mc.DstEs.ManageIndex(mc.DstIndexName, "delete"); err != nil {
+			return fmt.Errorf("cannot delete index: %s", err)
+		}
+		return mc.createIndex(body)
+	default:
+		return fmt.Errorf("destination index %s already exists, choose append, overwrite or upsert", mc.DstIndexName)
+	}
+}
+
+// dstIndexBody returns the source mappings and settings translated for the destination's version
+func (mc *MigrateConfig) dstIndexBody() (map[string]interface{}, error) {
+	body, err := mc.GetSrcIndexSettings()
+	if err != nil {
+		return nil, err
+	}
+	return convertIndexBody(body, mc.SrcEs.MajorVersion(), mc.DstEs.MajorVersion(), mc.TypeField), nil
+}
+
+func (mc *MigrateConfig) createIndex(body map[string]interface{}) error {
+	mJson, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("cannot marshal body: %s", err)
+	}
+
+	res, err := mc.DstEs.es.Indices.Create(mc.DstIndexName, mc.DstEs.es.Indices.Create.WithBody(bytes.NewReader(mJson)))
+	if err := checkElasticResp(res, err); err != nil {
+		return fmt.Errorf("cannot create index: %s", err)
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// CheckMappings compares the source fields, after the transform, with the destination
+// mappings and returns a description of every field whose types conflict
+func (mc *MigrateConfig) CheckMappings() ([]string, error) {
+	src, err := mc.SrcEs.Mapping(mc.SrcIndexName)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := mc.DstEs.Mapping(mc.DstIndexName)
+	if err != nil {
+		return nil, err
+	}
+
+	srcMappings, err := indexMappings(src, mc.SrcIndexName)
+	if err != nil {
+		return nil, err
+	}
+	dstMappings, err := indexMappings(dst, mc.DstIndexName)
+	if err != nil {
+		return nil, err
+	}
+
+	return mappingConflicts(mappingFields(srcMappings), mappingFields(dstMappings), mc.Transform), nil
+}
+
+// indexMappings returns the mappings of an index in a get mapping response, which is
+// keyed by the concrete index names
+func indexMappings(res map[string]interface{}, index string) (interface{}, error) {
+	m, ok := res[index].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("mapping for %s not found (alias or pattern?)", index)
+	}
+	return m["mappings"], nil
+}
+
+func mappingConflicts(srcFields, dstFields map[string]string, t *Transform) []string {
+	conflicts := []string{}
+
+	for field, srcType := range srcFields {
+		name := field
+		if t != nil {
+			if _, ok := t.Convert[field]; ok {
+				continue
+			}
+			if !t.keeps(field) {
+				continue
+			}
+			if to, ok := t.Rename[field]; ok {
+				name = to
+			}
+		}
+
+		dstType, ok := dstFields[name]
+		if !ok || dstType == srcType {
+			continue
+		}
+		if g, ok := compatibleTypes[srcType]; ok && g == compatibleTypes[dstType] {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s: %s -> %s", name, srcType, dstType))
+	}
+
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// keeps reports whether a field survives the include and exclude lists
+func (t *Transform) keeps(field string) bool {
+	for _, f := range t.Exclude {
+		if field == f || strings.HasPrefix(field, f+".") {
+			return false
+		}
+	}
+
+	if len(t.Include) == 0 {
+		return true
+	}
+	for _, f := range t.Include {
+		if field == f || strings.HasPrefix(field, f+".") || strings.HasPrefix(f, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingFields flattens typed (6.x) or typeless (7.x+) mappings into field name -> field type
+func mappingFields(mappings interface{}) map[string]string {
+	fields := map[string]string{}
+
+	m, ok := mappings.(map[string]interface{})
+	if !ok {
+		return fields
+	}
+
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		flattenProperties("", props, fields)
+		return fields
+	}
+
+	for _, typeMapping := range m {
+		if tm, ok := typeMapping.(map[string]interface{}); ok {
+			if props, ok := tm["properties"].(map[string]interface{}); ok {
+				flattenProperties("", props, fields)
+			}
+		}
+	}
+	return fields
+}
+
+func flattenProperties(prefix string, props map[string]interface{}, fields map[string]string) {
+	for name, v := range props {
+		f, _ := v.(map[string]interface{})
+		typ, _ := f["type"].(string)
+		if typ == "" {
+			typ = "object"
+		}
+		fields[prefix+name] = typ
+
+		if sub, ok := f["properties"].(map[string]interface{}); ok {
+			flattenProperties(prefix+name+".", sub, fields)
+		}
+	}
+}
+
+// bulkAction builds the bulk metadata line and document payload for the destination mode
+func (mc *MigrateConfig) bulkAction(id string, source map[string]interface{}) ([]byte, []byte, error) {
+	action := "index"
+	var doc interface{} = source
+
+	switch mc.DstMode {
+	case DstModeAppend:
+		action = "create"
+	case DstModeUpsert:
+		action = "update"
+		doc = map[string]interface{}{"doc": source, "doc_as_upsert": true}
+	}
+
+	meta, err := json.Marshal(map[string]interface{}{action: map[string]interface{}{"_id": id}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(meta, '\n'), data, nil
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_mappingConflicts(t *testing.T) {
+	typed := map[string]interface{}{
+		"doc": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"name":  map[string]interface{}{"type": "text"},
+				"price": map[string]interface{}{"type": "keyword"},
+				"count": map[string]interface{}{"type": "integer"},
+				"user": map[string]interface{}{
+					"properties": map[string]interface{}{"id": map[string]interface{}{"type": "keyword"}},
+				},
+			},
+		},
+	}
+	typeless := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "date"},
+			"price": map[string]interface{}{"type": "double"},
+			"count": map[string]interface{}{"type": "long"},
+			"user":  map[string]interface{}{"type": "nested"},
+		},
+	}
+
+	src := mappingFields(typed)
+	assert.Equal(t, "keyword", src["user.id"])
+	assert.Equal(t, "object", src["user"])
+
+	dst := mappingFields(typeless)
+	assert.Equal(t, []string{"price: keyword -> double", "user: object -> nested"}, mappingConflicts(src, dst, nil))
+
+	tr := &Transform{
+		Rename:  map[string]string{"name": "title"},
+		Convert: map[string]string{"price": "number"},
+		Exclude: []string{"user"},
+	}
+	assert.Equal(t, []string{"title: text -> date"}, mappingConflicts(src, dst, tr))
+}
+
+func Test_bulkAppendConflicts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"6.8.5"}}`))
+		case "/copy/_bulk":
+			w.Write([]byte(`{"errors":true,"items":[
+				{"create":{"_id":"1","status":201}},
+				{"create":{"_id":"2","status":409,"error":{"type":"version_conflict_engine_exception","reason":"document already exists"}}},
+				{"create":{"_id":"3","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}
+			]}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	dst, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.Nil(t, err)
+
+	mc := MigrateConfig{DstEs: dst, DstIndexName: "copy", DstMode: DstModeAppend}
+	var docs []bulkDoc
+	for _, id := range []string{"1", "2", "3"} {
+		doc, err := mc.prepare(id, "", map[string]interface{}{"id": id})
+		assert.Nil(t, err)
+		docs = append(docs, doc)
+	}
+
+	indexed, transient, failed := mc.bulk(docs, "copy")
+	assert.Equal(t, 1, indexed)
+	assert.Empty(t, transient)
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, "3", failed[0].doc.ID)
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&mc.NumSkipped))
+
+	// Conflicts are failures in the other modes
+	mc = MigrateConfig{DstEs: dst, DstIndexName: "copy", DstMode: DstModeOverwrite}
+	_, _, failed = mc.bulk(docs, "copy")
+	assert.Len(t, failed, 2)
+	assert.Equal(t, int64(0), mc.Stats().Skipped)
+}
+
+func Test_CreateDstIndexProtected(t *testing.T) {
+	var deleted int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "DELETE" {
+			atomic.AddInt32(&deleted, 1)
+		}
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+		default:
+			w.Write([]byte(`{"acknowledged":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	dst, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}, Protected: []string{"prod-*"}})
+	assert.NoError(t, err)
+
+	mc := MigrateConfig{DstEs: dst, DstIndexName: "prod-logs", DstMode: DstModeOverwrite, Confirm: "prod-logs"}
+	err = mc.CreateDstIndex()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "protected by prod-*")
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&deleted))
+}
+
+func Test_CheckMappingsAlias(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+		case "/logs/_mapping", "/copy/_mapping":
+			// logs is an alias of logs-000001
+			w.Write([]byte(`{"logs-000001":{"mappings":{"properties":{"a":{"type":"keyword"}}}},
+				"copy":{"mappings":{"properties":{"a":{"type":"keyword"}}}}}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+
+	mc := MigrateConfig{SrcEs: cl, DstEs: cl, SrcIndexName: "logs", DstIndexName: "copy"}
+	_, err = mc.CheckMappings()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "mapping for logs not found")
+	}
+
+	mc.SrcIndexName = "copy"
+	conflicts, err := mc.CheckMappings()
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+}
+
+func Test_flushRetried(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+		case "/copy/_bulk":
+			// Both batches of the first pass are rejected
+			status := 201
+			if atomic.AddInt32(&requests, 1) <= 2 {
+				status = 429
+			}
+			w.Write([]byte(fmt.Sprintf(`{"errors":%t,"items":[{"index":{"status":%d}},{"index":{"status":%d}}]}`, status != 201, status, status)))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	dst, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+
+	mc := MigrateConfig{DstEs: dst, DstIndexName: "copy", MaxRetries: 3, throttle: newThrottle(2)}
+	var docs []bulkDoc
+	for _, id := range []string{"1", "2", "3", "4"} {
+		doc, err := mc.prepare(id, "", map[string]interface{}{"id": id})
+		assert.NoError(t, err)
+		docs = append(docs, doc)
+	}
+
+	indexed, errors := mc.flush(docs)
+	assert.Equal(t, 4, indexed)
+	assert.Equal(t, 0, errors)
+	assert.Equal(t, int64(4), atomic.LoadInt64(&mc.NumRetried))
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/dustin/go-humanize"
+	"github.com/elastic/go-elasticsearch/v6/esapi"
 	"log"
+	"math"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,20 +18,70 @@ import (
 
 // Dump represents a database dump
 type MigrateConfig struct {
-	DocChan       chan Scroll
-	MiddleCh      chan Scroll
-	Closing       chan string
-	Closed        chan struct{}
-	SrcIndexName  string
-	DstIndexName  string
-	Fields        []string
-	SrcEs         *Client
-	DstEs         *Client
-	Size          int
-	Workers       int
-	NumScrolled   int64
-	NumBulked     int64
-	NumMigrations int64
+	DocChan        chan Scroll
+	MiddleCh       chan Scroll
+	Closing        chan string
+	Closed         chan struct{}
+	SrcIndexName   string
+	DstIndexName   string
+	Transform      *Transform
+	Where          string   // SQL WHERE clause restricting the migrated documents
+	Query          string   // DSL query restricting the migrated documents
+	Sort           []string // Scroll sort, "field:order" items
+	DstMode        string   // What to do with an existing destination index, see DstMode* constants
+	DstMappings    string   // Whose mappings and settings to use, see Mappings* constants
+	TypeField      string   // Keep the source _type in this field, drop it when empty
+	Confirm        string   // Must repeat the destination index name to overwrite it
+	SrcEs          *Client
+	DstEs          *Client
+	Size           int           // Scroll page size per slice
+	Slices         int           // Number of parallel scroll slices
+	BatchSize      int           // Maximum number of documents per bulk request
+	KeepAlive      time.Duration // Scroll context keep-alive
+	Workers        int           // Number of bulk workers
+	MaxRetries     int           // Attempts for documents failing with a transient error
+	DeadLetterFile string        // NDJSON file collecting the documents which could not be migrated
+	NumScrolled    int64
+	NumBulked      int64
+	NumFailed      int64
+	NumRetried     int64
+	NumSkipped     int64 // Documents kept in the destination in append mode
+	NumMigrations  int64
+
+	mu         sync.Mutex // guards throttle and startedAt for Stats
+	throttle   *throttle
+	total      int64
+	startedAt  time.Time
+	throughput uint64 // docs/sec, float64 bits
+	dead       *ndjsonWriter
+	docType    string // bulk document type, 6.x destinations only
+}
+
+// Defaults used by migration and export when not configured
+const (
+	DefaultScrollSize = 100
+	DefaultSlices     = 5
+	DefaultBatchSize  = 1000
+	DefaultKeepAlive  = time.Minute
+	DefaultWorkers    = 10
+	DefaultMaxRetries = 5
+)
+
+// MigrateStats is a snapshot of a running or finished migration
+type MigrateStats struct {
+	SrcIndexName string    `json:"src_index"`
+	DstIndexName string    `json:"dst_index"`
+	Total        int64     `json:"total"`
+	Scrolled     int64     `json:"scrolled"`
+	Bulked       int64     `json:"bulked"`
+	BatchSize    int       `json:"batch_size"`
+	Rejections   int64     `json:"rejections"`
+	Failed       int64     `json:"failed"`
+	Retried      int64     `json:"retried"`
+	Skipped      int64     `json:"skipped"`
+	DeadLetter   string    `json:"dead_letter,omitempty"`
+	DocsPerSec   float64   `json:"docs_per_sec"`
+	StartedAt    time.Time `json:"started_at"`
 }
 
 type Scroll struct {
@@ -38,36 +90,126 @@ type Scroll struct {
 }
 
 type BulkResp struct {
-	Errors bool `json:"errors"`
-	Items  []struct {
-		Index struct {
-			ID     string `json:"_id"`
-			Result string `json:"result"`
-			Status int    `json:"status"`
-			Error  struct {
-				Type   string `json:"type"`
-				Reason string `json:"reason"`
-				Cause  struct {
-					Type   string `json:"type"`
-					Reason string `json:"reason"`
-				} `json:"caused_by"`
-			} `json:"error"`
-		} `json:"index"`
-	} `json:"items"`
+	Errors bool                  `json:"errors"`
+	Items  []map[string]BulkItem `json:"items"`
+}
+
+// BulkItem is the result of one bulk action, keyed by the action name (index, create, update)
+type BulkItem struct {
+	ID     string `json:"_id"`
+	Result string `json:"result"`
+	Status int    `json:"status"`
+	Error  struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+		Cause  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"caused_by"`
+	} `json:"error"`
+}
+
+// setDefaults fills in the tuning options left empty
+func (mc *MigrateConfig) setDefaults() {
+	if mc.Size <= 0 {
+		mc.Size = DefaultScrollSize
+	}
+	if mc.Slices <= 0 {
+		mc.Slices = DefaultSlices
+	}
+	if mc.BatchSize <= 0 {
+		mc.BatchSize = DefaultBatchSize
+	}
+	if mc.KeepAlive <= 0 {
+		mc.KeepAlive = DefaultKeepAlive
+	}
+	if mc.Workers <= 0 {
+		mc.Workers = DefaultWorkers
+	}
+	if mc.MaxRetries <= 0 {
+		mc.MaxRetries = DefaultMaxRetries
+	}
+	if mc.DeadLetterFile == "" {
+		mc.DeadLetterFile = dataFileName(deadLetterKind, mc.DstIndexName)
+	}
+}
+
+// Stats returns the progress and the effective throughput of the migration
+func (mc *MigrateConfig) Stats() MigrateStats {
+	st := MigrateStats{
+		SrcIndexName: mc.SrcIndexName,
+		DstIndexName: mc.DstIndexName,
+		Total:        atomic.LoadInt64(&mc.total),
+		Scrolled:     atomic.LoadInt64(&mc.NumScrolled),
+		Bulked:       atomic.LoadInt64(&mc.NumBulked),
+		Failed:       atomic.LoadInt64(&mc.NumFailed),
+		Retried:      atomic.LoadInt64(&mc.NumRetried),
+		Skipped:      atomic.LoadInt64(&mc.NumSkipped),
+		DocsPerSec:   math.Float64frombits(atomic.LoadUint64(&mc.throughput)),
+	}
+	if st.Failed > 0 {
+		st.DeadLetter = mc.DeadLetterFile
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	st.StartedAt = mc.startedAt
+	if mc.throttle != nil {
+		st.BatchSize = mc.throttle.Batch()
+		st.Rejections = mc.throttle.Rejections()
+	}
+	return st
+}
+
+// reportThroughput logs the bulk rate whenever it changes noticeably, until done is closed
+func (mc *MigrateConfig) reportThroughput(done chan struct{}) {
+	ticker := time.NewTicker(throughputPeriod)
+	defer ticker.Stop()
+
+	var lastBulked int64
+	var lastRate float64
+	lastBatch := mc.throttle.Batch()
+	last := time.Now()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			bulked := atomic.LoadInt64(&mc.NumBulked)
+			rate := float64(bulked-lastBulked) / now.Sub(last).Seconds()
+			atomic.StoreUint64(&mc.throughput, math.Float64bits(rate))
+
+			batch := mc.throttle.Batch()
+			if batch != lastBatch || math.Abs(rate-lastRate) > lastRate*throughputChanged {
+				log.Printf("Migrating %s: %s/%s documents, %s docs/sec, batch size %d",
+					mc.SrcIndexName,
+					humanize.Comma(bulked),
+					humanize.Comma(atomic.LoadInt64(&mc.total)),
+					humanize.Comma(int64(rate)),
+					batch,
+				)
+			}
+			lastBulked, lastRate, lastBatch, last = bulked, rate, batch, now
+		}
+	}
 }
 
 func (mc *MigrateConfig) Export(c *Client, writer io.Writer) error {
 	var (
 		scrollID string
 		r        searchResponse
-		size     = 100
 	)
 
+	mc.setDefaults()
+
+	// The scroll pages keep the timeout of the search
 	res, err := c.es.Search(
 		c.es.Search.WithIndex(mc.SrcIndexName),
 		c.es.Search.WithSort("_doc"),
-		c.es.Search.WithSize(size),
-		c.es.Search.WithScroll(time.Minute),
+		c.es.Search.WithSize(mc.Size),
+		c.es.Search.WithScroll(mc.KeepAlive),
+		c.es.Search.WithTimeout(c.searchTimeout()),
 	)
 
 	if err := checkElasticResp(res, err); err != nil {
@@ -91,7 +233,7 @@ func (mc *MigrateConfig) Export(c *Client, writer io.Writer) error {
 
 	for {
 		// (scroll example) https://github.com/elastic/go-elasticsearch/issues/44
-		res, err := c.es.Scroll(c.es.Scroll.WithScrollID(scrollID), c.es.Scroll.WithScroll(time.Minute))
+		res, err := c.nextScroll(scrollID, mc.KeepAlive)
 		if err := checkElasticResp(res, err); err != nil {
 			return err
 		}
@@ -120,10 +262,18 @@ func (mc *MigrateConfig) Stop(by string) {
 	}
 }
 
+// start prepares the state shared by the bulk workers
+func (mc *MigrateConfig) start() {
+	mc.setDefaults()
+	mc.mu.Lock()
+	mc.throttle = newThrottle(mc.BatchSize)
+	mc.startedAt = time.Now()
+	mc.mu.Unlock()
+	mc.dead = newNDJSONWriter(mc.DeadLetterFile)
+}
+
 func (mc *MigrateConfig) Init(wg *sync.WaitGroup) {
-	if mc.Workers == 0 {
-		mc.Workers = 10
-	}
+	mc.start()
 
 	mc.DocChan = make(chan Scroll, 1000)
 	mc.MiddleCh = make(chan Scroll, 1000)
@@ -142,21 +292,39 @@ func (mc *MigrateConfig) Init(wg *sync.WaitGroup) {
 func (mc *MigrateConfig) Migrate() error {
 	wg := sync.WaitGroup{}
 
+	if err := mc.Transform.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := mc.SearchQuery(); err != nil {
+		return err
+	}
+
 	if err := mc.CreateDstIndex(); err != nil {
 		return err
 	}
 
+	if err := mc.setDocType(); err != nil {
+		return err
+	}
+
 	mc.Init(&wg)
 	var stoppedBy string
 
-	sc, total, err := mc.NewSlicedScroll()
+	total, err := mc.Count()
 	if err != nil {
-		return fmt.Errorf("error scroll: %s", err)
+		return fmt.Errorf("error count: %s", err)
 	}
 
-	if mc.NumMigrations > 0 {
+	if mc.NumMigrations > 0 && mc.NumMigrations < total {
 		total = mc.NumMigrations
 	}
+	atomic.StoreInt64(&mc.total, total)
+
+	sc, err := mc.NewSlicedScroll()
+	if err != nil {
+		return fmt.Errorf("error scroll: %s", err)
+	}
 
 	// middle goroutine
 	go func() {
@@ -189,15 +357,24 @@ func (mc *MigrateConfig) Migrate() error {
 
 	}()
 
+	scrolling := sync.WaitGroup{}
 	for _, i := range sc {
 		mc.MiddleCh <- i
 		atomic.AddInt64(&mc.NumScrolled, int64(len(i.Hits.Hits)))
+		scrolling.Add(1)
 		go func(sid string) {
 			for mc.NextScroll(sid) == false {
 			}
+			scrolling.Done()
 		}(i.ScrollID)
 	}
 
+	// stop once every slice is exhausted, even if fewer documents than counted were found
+	go func() {
+		scrolling.Wait()
+		mc.Stop("stop-> finished scrolling")
+	}()
+
 	// monitor scrolled doc and stop
 	go func() {
 		for {
@@ -206,65 +383,112 @@ func (mc *MigrateConfig) Migrate() error {
 				mc.Stop("stop-> reach the total")
 				break
 			}
+			select {
+			case <-mc.Closed:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
 		}
 	}()
 
+	done := make(chan struct{})
+	go mc.reportThroughput(done)
+
 	wg.Wait()
+	close(done)
+	if err := mc.dead.Close(); err != nil {
+		log.Printf("Cannot close dead-letter file %s: %s", mc.DeadLetterFile, err)
+	}
 	fmt.Printf("numItems: %d, numIndexed: %d", atomic.LoadInt64(&mc.NumScrolled), atomic.LoadInt64(&mc.NumBulked))
 	fmt.Println("stop by", stoppedBy)
 
 	return nil
 }
 
-func (mc *MigrateConfig) NewSlicedScroll() ([]Scroll, int64, error) {
-	var (
-		scrolls   []Scroll
-		total     int64
-		maxSliced = 5
-	)
+// SearchQuery returns the query clause selecting the migrated documents
+func (mc *MigrateConfig) SearchQuery() (map[string]interface{}, error) {
+	if mc.Where != "" && mc.Query != "" {
+		return nil, fmt.Errorf("only one of where and query can be set")
+	}
+
+	q := map[string]interface{}{"match_all": map[string]interface{}{}}
+	switch {
+	case mc.Where != "":
+		return whereToQuery(mc.Where)
+	case mc.Query != "":
+		if err := json.Unmarshal([]byte(mc.Query), &q); err != nil {
+			return nil, fmt.Errorf("invalid query: %s", err)
+		}
+		// accept a full search body as well as a bare query clause
+		if inner, ok := q["query"].(map[string]interface{}); ok {
+			q = inner
+		}
+	}
+	return q, nil
+}
+
+// Count returns the number of source documents matching the migration query
+func (mc *MigrateConfig) Count() (int64, error) {
+	q, err := mc.SearchQuery()
+	if err != nil {
+		return 0, err
+	}
+	return countDocs(mc.SrcEs, mc.SrcIndexName, q)
+}
+
+func (mc *MigrateConfig) NewSlicedScroll() ([]Scroll, error) {
+	var scrolls []Scroll
+
+	q, err := mc.SearchQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	sort := mc.Sort
+	if len(sort) == 0 {
+		sort = []string{"_doc"}
+	}
+
+	for i := 0; i < mc.Slices; i++ {
+		search := map[string]interface{}{"query": q}
+		// a single slice is a plain scroll
+		if mc.Slices > 1 {
+			search["slice"] = map[string]interface{}{"id": i, "max": mc.Slices}
+		}
+
+		body, err := json.Marshal(search)
+		if err != nil {
+			return nil, err
+		}
 
-	dsl := `{
-    "slice": {
-        "id": %d,
-        "max": %d
-    },
-    "query": {
-        "match_all" : {}
-    }}`
-	for i := 0; i < maxSliced; i++ {
-		var buf bytes.Buffer
-		buf.WriteString(fmt.Sprintf(dsl, i, maxSliced))
 		res, err := mc.SrcEs.es.Search(
 			mc.SrcEs.es.Search.WithIndex(mc.SrcIndexName),
-			mc.SrcEs.es.Search.WithBody(&buf),
-			mc.SrcEs.es.Search.WithSort("_doc"),
-			mc.SrcEs.es.Search.WithSize(100),
-			mc.SrcEs.es.Search.WithScroll(time.Minute),
+			mc.SrcEs.es.Search.WithBody(bytes.NewReader(body)),
+			mc.SrcEs.es.Search.WithSort(sort...),
+			mc.SrcEs.es.Search.WithSize(mc.Size),
+			mc.SrcEs.es.Search.WithScroll(mc.KeepAlive),
 		)
 
 		if err := checkElasticResp(res, err); err != nil {
-			return nil, 0, err
+			return nil, err
 		}
 
 		scroll := Scroll{}
 		if err := json.NewDecoder(res.Body).Decode(&scroll); err != nil {
-			return nil, 0, err
+			return nil, err
 		}
-		total += int64(scroll.Hits.Total)
 		scrolls = append(scrolls, scroll)
 
 		res.Body.Close()
 
 	}
 
-	return scrolls, total, nil
+	return scrolls, nil
 }
 
 func (mc *MigrateConfig) NextScroll(sid string) (done bool) {
 
-	res, err := mc.SrcEs.es.Scroll(
-		mc.SrcEs.es.Scroll.WithScrollID(sid),
-		mc.SrcEs.es.Scroll.WithScroll(time.Minute))
+	res, err := mc.SrcEs.nextScroll(sid, mc.KeepAlive)
 
 	if err := checkElasticResp(res, err); err != nil {
 		log.Printf("Error scroll: %s", err)
@@ -299,55 +523,58 @@ func (mc *MigrateConfig) NextScroll(sid string) (done bool) {
 	return false
 }
 
+// bulkDoc is one prepared bulk action
+type bulkDoc struct {
+	ID       string
+	Type     string
+	Meta     []byte
+	Data     []byte
+	Source   map[string]interface{} // original source, kept for the dead-letter file
+	Attempts int
+}
+
+// bulkFailure is a document the destination did not accept
+type bulkFailure struct {
+	doc    bulkDoc
+	status int
+	typ    string
+	reason string
+}
+
 func (mc *MigrateConfig) Bulk() {
 	var (
-		buf        bytes.Buffer
+		docs       []bulkDoc
 		numErrors  int
 		numIndexed int
 	)
 
-	batch := 1000
 	start := time.Now().UTC()
 
 	// Loop over the collection
-	count := 0
 	for r := range mc.DocChan {
 		for _, a := range r.Hits.Hits {
-			count++
-
-			// Prepare the metadata payload
-			meta := []byte(fmt.Sprintf(`{ "index" : { "_id" : "%s" } }%s`, a.ID, "\n"))
-			// fmt.Printf("%s", meta)
-
-			// Prepare the data payload: encode article to JSON
-			data, err := json.Marshal(a.Source)
+			doc, err := mc.prepare(a.ID, a.Type, a.Source)
 			if err != nil {
-				log.Fatalf("Cannot encode article %s: %s", a.ID, err)
+				log.Printf("Cannot prepare document %s: %s", a.ID, err)
+				mc.deadLetter(bulkFailure{doc: bulkDoc{ID: a.ID, Type: a.Type, Source: a.Source}, typ: "transform", reason: err.Error()})
+				numErrors++
+				continue
 			}
 
-			// Append newline to the data payload
-			data = append(data, "\n"...) // <-- Comment out to trigger failure for batch
-
-			buf.Grow(len(meta) + len(data))
-			buf.Write(meta)
-			buf.Write(data)
-			// When a threshold is reached, execute the Bulk() request with body from buffer
-			if count >= batch {
-				batchIndexed, batchErrors := mc.bulk(buf, mc.DstIndexName)
+			docs = append(docs, doc)
+			// When the current batch size is reached, execute the Bulk() request
+			if len(docs) >= mc.throttle.Batch() {
+				batchIndexed, batchErrors := mc.flush(docs)
 				numIndexed += batchIndexed
 				numErrors += batchErrors
-				// Reset the buffer and items counter
-				count = 0
-				atomic.AddInt64(&mc.NumBulked, int64(numIndexed))
-				buf.Reset()
+				docs = nil
 			}
 		}
 	}
 
-	if buf.Len() > 0 {
-		batchIndexed, batchErrors := mc.bulk(buf, mc.DstIndexName)
+	if len(docs) > 0 {
+		batchIndexed, batchErrors := mc.flush(docs)
 		numIndexed += batchIndexed
-		atomic.AddInt64(&mc.NumBulked, int64(numIndexed))
 		numErrors += batchErrors
 	}
 
@@ -359,12 +586,13 @@ func (mc *MigrateConfig) Bulk() {
 	dur := time.Since(start)
 
 	if numErrors > 0 {
-		log.Fatalf(
-			"Indexed [%s] documents with [%s] errors in %s (%s docs/sec)",
+		log.Printf(
+			"Indexed [%s] documents with [%s] errors in %s (%s docs/sec), failed documents in %s",
 			humanize.Comma(int64(numIndexed)),
 			humanize.Comma(int64(numErrors)),
 			dur.Truncate(time.Millisecond),
 			humanize.Comma(int64(1000.0/float64(dur/time.Millisecond)*float64(numIndexed))),
+			mc.DeadLetterFile,
 		)
 	} else {
 		log.Printf(
@@ -377,111 +605,255 @@ func (mc *MigrateConfig) Bulk() {
 
 }
 
-func (mc *MigrateConfig) bulk(buf bytes.Buffer, index string) (int, int) {
+// prepare transforms a source document and builds its bulk action
+func (mc *MigrateConfig) prepare(id, typ string, src map[string]interface{}) (bulkDoc, error) {
+	source, err := mc.transformDoc(typ, src)
+	if err != nil {
+		return bulkDoc{}, err
+	}
+
+	// Prepare the metadata and data payload for the destination mode
+	meta, data, err := mc.bulkAction(id, source)
+	if err != nil {
+		return bulkDoc{}, err
+	}
+
+	// Append newline to the data payload
+	data = append(data, "\n"...) // <-- Comment out to trigger failure for batch
+
+	return bulkDoc{ID: id, Type: typ, Meta: meta, Data: data, Source: src}, nil
+}
+
+// flush sends the documents in batches of the current size. Documents failing with a
+// transient error are sent again after backing off, up to MaxRetries times, the others
+// go to the dead-letter file.
+func (mc *MigrateConfig) flush(docs []bulkDoc) (int, int) {
+	var numIndexed, numErrors int
+
+	for len(docs) > 0 {
+		var retry []bulkDoc
+
+		for len(docs) > 0 {
+			n := mc.throttle.Batch()
+			if n > len(docs) {
+				n = len(docs)
+			}
+
+			batchIndexed, transient, failed := mc.bulk(docs[:n], mc.DstIndexName)
+			numIndexed += batchIndexed
+			atomic.AddInt64(&mc.NumBulked, int64(batchIndexed))
+
+			for _, f := range failed {
+				mc.deadLetter(f)
+			}
+			numErrors += len(failed)
+
+			if len(transient) > 0 {
+				for _, f := range transient {
+					if f.doc.Attempts++; f.doc.Attempts > mc.MaxRetries {
+						mc.deadLetter(f)
+						numErrors++
+						continue
+					}
+					retry = append(retry, f.doc)
+				}
+
+				wait := mc.throttle.reject()
+				log.Printf("Destination rejected %d documents, batch size %d, backing off %s",
+					len(transient), mc.throttle.Batch(), wait)
+				time.Sleep(wait)
+			} else {
+				mc.throttle.success()
+			}
+			docs = docs[n:]
+		}
+		atomic.AddInt64(&mc.NumRetried, int64(len(retry)))
+		docs = retry
+	}
+
+	return numIndexed, numErrors
+}
+
+// bulk sends one bulk request and returns the number of indexed documents, the documents
+// which failed with a transient error and may be sent again, and the failed documents
+func (mc *MigrateConfig) bulk(docs []bulkDoc, index string) (int, []bulkFailure, []bulkFailure) {
 	var (
-		raw        map[string]interface{}
-		numErrors  int
+		buf        bytes.Buffer
+		raw        elasticErrResp
 		numIndexed int
+		transient  []bulkFailure
+		failed     []bulkFailure
 		blk        *BulkResp
 	)
 
-	res, err := mc.DstEs.es.Bulk(
-		bytes.NewReader(buf.Bytes()),
-		mc.DstEs.es.Bulk.WithIndex(index),
-		mc.DstEs.es.Bulk.WithDocumentType("documents"),
-	)
+	allFailed := func(status int, typ, reason string) []bulkFailure {
+		f := make([]bulkFailure, len(docs))
+		for i, d := range docs {
+			f[i] = bulkFailure{doc: d, status: status, typ: typ, reason: reason}
+		}
+		return f
+	}
+
+	for _, d := range docs {
+		buf.Grow(len(d.Meta) + len(d.Data))
+		buf.Write(d.Meta)
+		buf.Write(d.Data)
+	}
+
+	opts := []func(*esapi.BulkRequest){mc.DstEs.es.Bulk.WithIndex(index)}
+	if mc.docType != "" {
+		opts = append(opts, mc.DstEs.es.Bulk.WithDocumentType(mc.docType))
+	}
+
+	res, err := mc.DstEs.es.Bulk(bytes.NewReader(buf.Bytes()), opts...)
 	if err != nil {
-		log.Fatalf("Failure indexing %s", err)
+		log.Printf("Failure indexing %s", err)
+		return 0, allFailed(0, "transport", err.Error()), nil
 	}
 	defer res.Body.Close()
+	defer mc.DstEs.InvalidateCache(index)
 
 	// If the whole request failed, print error and mark all documents as failed
 	//
 	if res.IsError() {
 		if err := json.NewDecoder(res.Body).Decode(&raw); err != nil {
-			log.Fatalf("Failure to to parse response body: %s", err)
-		} else {
-			log.Printf("  Error: [%d] %s: %s",
-				res.StatusCode,
-				raw["error"].(map[string]interface{})["type"],
-				raw["error"].(map[string]interface{})["reason"],
-			)
+			raw.Error.Type = "http_error"
+			raw.Error.Reason = res.Status()
 		}
-		// A successful response might still contain errors for particular documents...
-		//
-	} else {
-		if err := json.NewDecoder(res.Body).Decode(&blk); err != nil {
-			log.Fatalf("Failure to to parse response body: %s", err)
-		} else {
-			for _, d := range blk.Items {
-				// ... so for any HTTP status above 201 ...
+		log.Printf("  Error: [%d] %s: %s", res.StatusCode, raw.Error.Type, raw.Error.Reason)
+
+		if isTransient(res.StatusCode, raw.Error.Type) {
+			return 0, allFailed(res.StatusCode, raw.Error.Type, raw.Error.Reason), nil
+		}
+		return 0, nil, allFailed(res.StatusCode, raw.Error.Type, raw.Error.Reason)
+	}
+
+	// A successful response might still contain errors for particular documents...
+	//
+	if err := json.NewDecoder(res.Body).Decode(&blk); err != nil {
+		log.Printf("Failure to to parse response body: %s", err)
+		return 0, nil, allFailed(res.StatusCode, "parse_error", err.Error())
+	}
+
+	for i, item := range blk.Items {
+		for _, d := range item {
+			if d.Status <= 201 {
+				// ... increase the success counter ...
 				//
-				if d.Index.Status > 201 {
-					// ... increment the error counter ...
-					//
-					numErrors++
-
-					// ... and print the response status and error information ...
-					log.Printf("  Error: [%d]: %s: %s: %s: %s",
-						d.Index.Status,
-						d.Index.Error.Type,
-						d.Index.Error.Reason,
-						d.Index.Error.Cause.Type,
-						d.Index.Error.Cause.Reason,
-					)
-				} else {
-					// ... otherwise increase the success counter.
-					//
-					numIndexed++
-				}
+				numIndexed++
+				continue
+			}
+			if i >= len(docs) {
+				continue
+			}
+
+			// ... documents already in the destination are kept in append mode ...
+			//
+			if d.Status == 409 && mc.DstMode == DstModeAppend {
+				atomic.AddInt64(&mc.NumSkipped, 1)
+				continue
+			}
+
+			// ... otherwise print the response status and error information ...
+			//
+			log.Printf("  Error: [%d]: %s: %s: %s: %s",
+				d.Status,
+				d.Error.Type,
+				d.Error.Reason,
+				d.Error.Cause.Type,
+				d.Error.Cause.Reason,
+			)
+
+			f := bulkFailure{doc: docs[i], status: d.Status, typ: d.Error.Type, reason: d.Error.Reason}
+			if d.Error.Cause.Reason != "" {
+				f.reason += ": " + d.Error.Cause.Reason
+			}
+
+			// ... and send the document again if the destination was only overloaded
+			//
+			if isTransient(d.Status, d.Error.Type) {
+				transient = append(transient, f)
+			} else {
+				failed = append(failed, f)
 			}
 		}
 	}
 
-	return numIndexed, numErrors
+	return numIndexed, transient, failed
 }
 
-func (mc *MigrateConfig) CreateDstIndex() error {
-	body, err := mc.GetSrcIndexSettings()
-	if err != nil {
-		return fmt.Errorf("error get index settings: %s", err)
+// isTransient reports whether a failed request or document may succeed when sent again
+func isTransient(status int, errType string) bool {
+	switch status {
+	case 429, 502, 503, 504:
+		return true
 	}
+	return errType == "es_rejected_execution_exception"
+}
 
-	res, err := mc.DstEs.es.Indices.Exists([]string{mc.DstIndexName})
-	if err != nil {
-		return fmt.Errorf("error check exisits: %s", err)
+// PreviewDoc is a source document next to its transformed version
+type PreviewDoc struct {
+	ID          string                 `json:"_id"`
+	Source      map[string]interface{} `json:"source"`
+	Transformed map[string]interface{} `json:"transformed,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// Preview fetches the first size documents of the source index and applies the transform
+func (mc *MigrateConfig) Preview(size int) ([]PreviewDoc, error) {
+	if err := mc.Transform.Validate(); err != nil {
+		return nil, err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode == 200 {
-		err := mc.DstEs.ManageIndex(mc.DstIndexName, "delete")
-		if err != nil {
-			return fmt.Errorf("cannot delete index: %s", err)
-		}
+	q, err := mc.SearchQuery()
+	if err != nil {
+		return nil, err
 	}
 
-	mJson, err := json.Marshal(body)
+	body, err := json.Marshal(map[string]interface{}{"query": q})
 	if err != nil {
-		return fmt.Errorf("cannot marshal body: %s", err)
+		return nil, err
 	}
 
-	var buf bytes.Buffer
-	buf.Write(mJson)
-	res, err = mc.DstEs.es.Indices.Create(mc.DstIndexName, mc.DstEs.es.Indices.Create.WithBody(&buf))
+	res, err := mc.SrcEs.es.Search(
+		mc.SrcEs.es.Search.WithIndex(mc.SrcIndexName),
+		mc.SrcEs.es.Search.WithBody(bytes.NewReader(body)),
+		mc.SrcEs.es.Search.WithSort(mc.Sort...),
+		mc.SrcEs.es.Search.WithSize(size),
+	)
 	if err := checkElasticResp(res, err); err != nil {
-		return fmt.Errorf("cannot create index: %s", err)
+		return nil, err
 	}
+	defer res.Body.Close()
 
-	return nil
+	var r searchResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	docs := make([]PreviewDoc, 0, len(r.Hits.Hits))
+	for _, hit := range r.Hits.Hits {
+		d := PreviewDoc{ID: hit.ID, Source: hit.Source}
+		if d.Transformed, err = mc.transformDoc(hit.Type, hit.Source); err != nil {
+			d.Error = err.Error()
+		}
+		docs = append(docs, d)
+	}
+	return docs, nil
 }
 
 func (mc *MigrateConfig) GetSrcIndexSettings() (map[string]interface{}, error) {
-	m, err := mc.SrcEs.Mapping(mc.SrcIndexName)
+	return indexBody(mc.SrcEs, mc.SrcIndexName)
+}
+
+// indexBody returns the mappings and settings of an index in a form usable to create it again
+func indexBody(c *Client, indexName string) (map[string]interface{}, error) {
+	m, err := c.Mapping(indexName)
 	if err != nil {
 		return nil, err
 	}
 
-	s, err := mc.SrcEs.Settings(mc.SrcIndexName)
+	s, err := c.Settings(indexName)
 	if err != nil {
 		return nil, err
 	}
@@ -493,12 +865,21 @@ func (mc *MigrateConfig) GetSrcIndexSettings() (map[string]interface{}, error) {
 		"version",
 	}
 
-	body := make(map[string]interface{})
-	body["mappings"] = m[mc.SrcIndexName].(map[string]interface{})["mappings"]
-	body["settings"] = s[mc.SrcIndexName].(map[string]interface{})["settings"]
+	mappings, err := indexMappings(m, indexName)
+	if err != nil {
+		return nil, err
+	}
+	index, _ := s[indexName].(map[string]interface{})
+	settings, ok := index["settings"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("settings for %s not found (alias or pattern?)", indexName)
+	}
 
-	for _, f := range invalidFields {
-		delete(body["settings"].(map[string]interface{})["index"].(map[string]interface{}), f)
+	body := map[string]interface{}{"mappings": mappings, "settings": settings}
+	if opts, ok := settings["index"].(map[string]interface{}); ok {
+		for _, f := range invalidFields {
+			delete(opts, f)
+		}
 	}
 	return body, nil
 }
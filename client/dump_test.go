@@ -19,6 +19,8 @@ func Test_dump(t *testing.T) {
 		DstIndexName:  dstIndexName,
 		Size:          size,
 		NumMigrations: 1200,
+		DstMode:       DstModeOverwrite,
+		Confirm:       dstIndexName,
 	}
 
 	err := dumper.Migrate()
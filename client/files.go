@@ -0,0 +1,123 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DataDir is where esweb keeps its files, such as the migration dead-letter files
+var DataDir = defaultDataDir()
+
+// defaultDataDir is $HOME/.esweb, or a temporary directory without a home directory
+func defaultDataDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".esweb")
+	}
+	return filepath.Join(os.TempDir(), "esweb")
+}
+
+// Kinds of files kept under DataDir, one directory each
+const (
+	deadLetterKind = "deadletter"
+	verifyKind     = "verify"
+	queriesKind    = "queries"
+)
+
+// DataFile describes a file kept under DataDir
+type DataFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^._\-\w]+`)
+
+func safeFileName(name string) string {
+	return unsafeFileChars.ReplaceAllString(name, "_")
+}
+
+// dataFileName returns a new timestamped NDJSON file path for an index
+func dataFileName(kind, index string) string {
+	return filepath.Join(DataDir, kind, fmt.Sprintf("%s-%s.ndjson",
+		safeFileName(index), time.Now().Format("20060102-150405")))
+}
+
+// dataFilePath returns the path of a file by name, refusing names outside the kind's directory
+func dataFilePath(kind, name string) (string, error) {
+	if name == "" || name != safeFileName(name) || name[0] == '.' {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return filepath.Join(DataDir, kind, name), nil
+}
+
+// listDataFiles returns the NDJSON files of a kind, newest first
+func listDataFiles(kind string) ([]DataFile, error) {
+	files := []DataFile{}
+
+	infos, err := ioutil.ReadDir(filepath.Join(DataDir, kind))
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range infos {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".ndjson" {
+			continue
+		}
+		files = append(files, DataFile{Name: fi.Name(), Size: fi.Size(), Modified: fi.ModTime()})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Modified.After(files[j].Modified) })
+	return files, nil
+}
+
+// ndjsonWriter appends JSON lines to a file, the file is created on the first write
+type ndjsonWriter struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	enc  *json.Encoder
+}
+
+func newNDJSONWriter(path string) *ndjsonWriter {
+	return &ndjsonWriter{path: path}
+}
+
+func (w *ndjsonWriter) Write(v interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := os.MkdirAll(filepath.Dir(w.path), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		w.file = f
+		w.enc = json.NewEncoder(f)
+	}
+	return w.enc.Encode(v)
+}
+
+func (w *ndjsonWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
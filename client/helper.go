@@ -3,16 +3,44 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/ll2l/elasticsql"
 	"io"
+	"regexp"
 	"strings"
 )
 
+var wherePrefix = regexp.MustCompile(`(?i)^where\s`)
+
 func unpack(s []string, vars ...*string) {
 	for i, str := range s {
 		*vars[i] = str
 	}
 }
 
+// Converts a SQL WHERE clause into a DSL query clause
+func whereToQuery(where string) (map[string]interface{}, error) {
+	where = strings.TrimSpace(where)
+	if wherePrefix.MatchString(where) {
+		where = strings.TrimSpace(where[6:])
+	}
+
+	dsl, _, err := elasticsql.Convert("SELECT * FROM tmp WHERE " + where)
+	if err != nil {
+		return nil, fmt.Errorf("invalid where clause: %s", err)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(dsl), &body); err != nil {
+		return nil, err
+	}
+
+	q, ok := body["query"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid where clause: %s", where)
+	}
+	return q, nil
+}
+
 func PrintPrettyMap(query map[string]interface{}) {
 	b, err := json.Marshal(query)
 	if err != nil {
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_whereToQuery(t *testing.T) {
+	q, err := whereToQuery("WHERE tenant = 'acme'")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": []interface{}{
+				map[string]interface{}{"match_phrase": map[string]interface{}{"tenant": map[string]interface{}{"query": "acme"}}},
+			},
+		},
+	}, q)
+
+	tabbed, err := whereToQuery("where\n\ttenant = 'acme'")
+	assert.Nil(t, err)
+	assert.Equal(t, q, tabbed)
+
+	mc := MigrateConfig{Query: `{"query": {"term": {"tenant": "acme"}}}`}
+	q, err = mc.SearchQuery()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"term": map[string]interface{}{"tenant": "acme"}}, q)
+
+	mc.Where = "tenant = 'acme'"
+	_, err = mc.SearchQuery()
+	assert.NotNil(t, err)
+}
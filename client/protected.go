@@ -0,0 +1,40 @@
+package client
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Index actions which lose data or take the index offline
+var destructiveActions = map[string]bool{
+	"delete": true,
+	"close":  true,
+	"freeze": true,
+}
+
+// IsDestructive reports whether an index action needs to be confirmed
+func IsDestructive(action string) bool {
+	return destructiveActions[action]
+}
+
+// CheckProtected refuses destructive actions on indices matching a protected pattern
+// of the bookmark. Wildcards could reach a protected index, so they are refused as well.
+func (c *Client) CheckProtected(index, action string) error {
+	if !IsDestructive(action) || len(c.Protected) == 0 {
+		return nil
+	}
+
+	for _, name := range strings.Split(index, ",") {
+		name = strings.TrimSpace(name)
+		if name == "_all" || strings.ContainsAny(name, "*?") {
+			return fmt.Errorf("cannot %s %s, wildcards are not allowed while indices of %s are protected", action, name, c.Alias)
+		}
+		for _, pattern := range c.Protected {
+			if ok, _ := path.Match(pattern, name); ok {
+				return fmt.Errorf("cannot %s %s, it is protected by %s", action, name, pattern)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckProtected(t *testing.T) {
+	c := &Client{Alias: "prod", Protected: []string{"prod-*", ".security*"}}
+
+	assert.Error(t, c.CheckProtected("prod-logs", "delete"))
+	assert.Error(t, c.CheckProtected(".security-7", "close"))
+	assert.Error(t, c.CheckProtected("dev-logs, prod-logs", "freeze"))
+	assert.Error(t, c.CheckProtected("dev-*", "delete"))
+	assert.Error(t, c.CheckProtected("_all", "close"))
+
+	assert.NoError(t, c.CheckProtected("dev-logs", "delete"))
+	assert.NoError(t, c.CheckProtected("prod-logs", "refresh"))
+	assert.NoError(t, c.CheckProtected("prod-*", "open"))
+
+	assert.NoError(t, (&Client{}).CheckProtected("prod-*", "delete"))
+}
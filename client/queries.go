@@ -0,0 +1,353 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Languages of saved queries
+const (
+	QuerySQL = "sql" // Run by Query
+	QueryDSL = "dsl" // A search body run by SearchWithBody
+)
+
+// SavedQuery is a named query kept per cluster alias. Its text may hold {{param}}
+// placeholders, which are filled in and escaped by Render.
+type SavedQuery struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Language    string    `json:"language"`
+	Text        string    `json:"text"`
+	Index       string    `json:"index,omitempty"` // Searched index of DSL queries
+	Updated     time.Time `json:"updated"`
+}
+
+// SavedQueryFile is the format of the saved query files, also used for import and export
+type SavedQueryFile struct {
+	Queries []SavedQuery `json:"queries"`
+}
+
+var (
+	queriesMu sync.Mutex
+
+	placeholder    = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	validQueryName = regexp.MustCompile(`^[\w][\w .-]*$`)
+)
+
+// Validate checks the query can be saved
+func (q SavedQuery) Validate() error {
+	if !validQueryName.MatchString(q.Name) {
+		return fmt.Errorf("invalid query name %q, use letters, digits, spaces, ., - and _", q.Name)
+	}
+	if q.Language != QuerySQL && q.Language != QueryDSL {
+		return fmt.Errorf("unknown query language %q, use %s or %s", q.Language, QuerySQL, QueryDSL)
+	}
+	if strings.TrimSpace(q.Text) == "" {
+		return fmt.Errorf("query %s has no text", q.Name)
+	}
+	return nil
+}
+
+// Params returns the placeholder names of the query in order of appearance
+func (q SavedQuery) Params() []string {
+	seen := map[string]bool{}
+	params := []string{}
+	for _, m := range placeholder.FindAllStringSubmatch(q.Text, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			params = append(params, m[1])
+		}
+	}
+	return params
+}
+
+// Render fills in the placeholders. A placeholder inside a string literal of the
+// language, such as '{{name}}' or '%{{name}}%' in SQL and "prefix-{{name}}" in DSL,
+// is replaced by the escaped value, a bare one by a literal: numbers and booleans
+// as they are, other values quoted.
+func (q SavedQuery) Render(values map[string]string) (string, error) {
+	missing := []string{}
+	for _, p := range q.Params() {
+		if _, ok := values[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing query parameters: %s", strings.Join(missing, ", "))
+	}
+
+	quote := byte('\'')
+	if q.Language == QueryDSL {
+		quote = '"'
+	}
+
+	text := q.Text
+	lit := literalState{language: q.Language}
+	var b strings.Builder
+	last := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(text, -1) {
+		val := values[text[loc[2]:loc[3]]]
+		lit.scan(text[last:loc[0]])
+
+		b.WriteString(text[last:loc[0]])
+		switch {
+		case lit.open != 0:
+			b.WriteString(escapeQueryString(q.Language, lit.open, val))
+		case isLiteral(val):
+			b.WriteString(val)
+		default:
+			b.WriteByte(quote)
+			b.WriteString(escapeQueryString(q.Language, quote, val))
+			b.WriteByte(quote)
+		}
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String(), nil
+}
+
+// literalState follows the string literals of a query text: ', " and ` quotes in SQL,
+// " in DSL
+type literalState struct {
+	language string
+	open     byte // Quote of the literal the text is in, 0 outside of literals
+	escaped  bool // The previous character was a backslash in a literal
+}
+
+func (s *literalState) scan(text string) {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case s.escaped:
+			s.escaped = false
+		case s.open == 0:
+			if c == '"' || (s.language != QueryDSL && (c == '\'' || c == '`')) {
+				s.open = c
+			}
+		case c == '\\' && s.open != '`':
+			s.escaped = true
+		case c == s.open:
+			// A doubled quote in SQL closes and opens the literal again
+			s.open = 0
+		}
+	}
+}
+
+// escapeQueryString escapes a value for a literal with the given quote, without the quotes
+func escapeQueryString(language string, quote byte, val string) string {
+	switch {
+	case language == QueryDSL:
+		b, _ := json.Marshal(val)
+		return string(b[1 : len(b)-1])
+	case quote == '`':
+		return strings.Replace(val, "`", "``", -1)
+	}
+	q := string(quote)
+	return strings.NewReplacer(`\`, `\\`, q, q+q).Replace(val)
+}
+
+func isLiteral(val string) bool {
+	if val == "true" || val == "false" {
+		return true
+	}
+	_, err := strconv.ParseFloat(val, 64)
+	return err == nil && !strings.ContainsAny(val, "xXnN")
+}
+
+// savedQueriesPath returns the saved query file of a cluster alias
+func savedQueriesPath(alias string) string {
+	if alias == "" {
+		alias = "default"
+	}
+	return filepath.Join(DataDir, queriesKind, safeFileName(alias)+".json")
+}
+
+func readSavedQueries(alias string) ([]SavedQuery, error) {
+	b, err := ioutil.ReadFile(savedQueriesPath(alias))
+	if os.IsNotExist(err) {
+		return []SavedQuery{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f SavedQueryFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("cannot read saved queries of %s: %s", alias, err)
+	}
+	if f.Queries == nil {
+		f.Queries = []SavedQuery{}
+	}
+	return f.Queries, nil
+}
+
+// writeSavedQueries replaces the saved query file, written next to it and renamed over it
+func writeSavedQueries(alias string, queries []SavedQuery) error {
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Name < queries[j].Name })
+	b, err := json.MarshalIndent(SavedQueryFile{Queries: queries}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := savedQueriesPath(alias)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".queries-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func findSavedQuery(queries []SavedQuery, name string) int {
+	for i, q := range queries {
+		if q.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ListSavedQueries returns the saved queries of a cluster alias, all of them when tag is empty
+func ListSavedQueries(alias, tag string) ([]SavedQuery, error) {
+	queriesMu.Lock()
+	defer queriesMu.Unlock()
+
+	queries, err := readSavedQueries(alias)
+	if err != nil || tag == "" {
+		return queries, err
+	}
+
+	tagged := []SavedQuery{}
+	for _, q := range queries {
+		for _, t := range q.Tags {
+			if t == tag {
+				tagged = append(tagged, q)
+				break
+			}
+		}
+	}
+	return tagged, nil
+}
+
+// GetSavedQuery returns a saved query by name
+func GetSavedQuery(alias, name string) (SavedQuery, error) {
+	queriesMu.Lock()
+	defer queriesMu.Unlock()
+
+	queries, err := readSavedQueries(alias)
+	if err != nil {
+		return SavedQuery{}, err
+	}
+	i := findSavedQuery(queries, name)
+	if i < 0 {
+		return SavedQuery{}, fmt.Errorf("saved query %s not found", name)
+	}
+	return queries[i], nil
+}
+
+// SaveQuery adds a query, or replaces the query name when it is not empty,
+// renaming it to q.Name
+func SaveQuery(alias, name string, q SavedQuery) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+
+	queriesMu.Lock()
+	defer queriesMu.Unlock()
+
+	queries, err := readSavedQueries(alias)
+	if err != nil {
+		return err
+	}
+
+	i := -1
+	if name != "" {
+		if i = findSavedQuery(queries, name); i < 0 {
+			return fmt.Errorf("saved query %s not found", name)
+		}
+	}
+	if j := findSavedQuery(queries, q.Name); j >= 0 && j != i {
+		return fmt.Errorf("saved query %s already exists", q.Name)
+	}
+
+	q.Updated = time.Now()
+	if i < 0 {
+		queries = append(queries, q)
+	} else {
+		queries[i] = q
+	}
+	return writeSavedQueries(alias, queries)
+}
+
+// DeleteSavedQuery removes a saved query
+func DeleteSavedQuery(alias, name string) error {
+	queriesMu.Lock()
+	defer queriesMu.Unlock()
+
+	queries, err := readSavedQueries(alias)
+	if err != nil {
+		return err
+	}
+	i := findSavedQuery(queries, name)
+	if i < 0 {
+		return fmt.Errorf("saved query %s not found", name)
+	}
+	return writeSavedQueries(alias, append(queries[:i], queries[i+1:]...))
+}
+
+// ImportSavedQueries adds queries from an exported file. Queries with a name already
+// in use are replaced when overwrite is set and skipped otherwise.
+func ImportSavedQueries(alias string, imported []SavedQuery, overwrite bool) (added, replaced, skipped int, err error) {
+	for _, q := range imported {
+		if err := q.Validate(); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	queriesMu.Lock()
+	defer queriesMu.Unlock()
+
+	queries, err := readSavedQueries(alias)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	for _, q := range imported {
+		if q.Updated.IsZero() {
+			q.Updated = time.Now()
+		}
+		i := findSavedQuery(queries, q.Name)
+		switch {
+		case i < 0:
+			queries = append(queries, q)
+			added++
+		case overwrite:
+			queries[i] = q
+			replaced++
+		default:
+			skipped++
+		}
+	}
+	return added, replaced, skipped, writeSavedQueries(alias, queries)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SavedQueryRender(t *testing.T) {
+	q := SavedQuery{Name: "by tenant", Language: QuerySQL,
+		Text: "select * from logs where tenant = {{tenant}} and note = '{{ note }}' and size > {{size}} and t = {{tenant}}"}
+	assert.Equal(t, []string{"tenant", "note", "size"}, q.Params())
+
+	_, err := q.Render(map[string]string{"tenant": "acme"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "note, size")
+	}
+
+	sql, err := q.Render(map[string]string{"tenant": "o'neil", "note": `it's \ ok`, "size": "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, `select * from logs where tenant = 'o''neil' and note = 'it''s \\ ok' and size > 10 and t = 'o''neil'`, sql)
+
+	sql, _ = q.Render(map[string]string{"tenant": "1 or 1=1", "note": "", "size": "NaN"})
+	assert.Equal(t, `select * from logs where tenant = '1 or 1=1' and note = '' and size > 'NaN' and t = '1 or 1=1'`, sql)
+
+	dsl := SavedQuery{Name: "dsl", Language: QueryDSL, Text: `{"query": {"term": {"user": "{{user}}"}}, "size": {{size}}, "x": {{x}}}`}
+	body, err := dsl.Render(map[string]string{"user": `a"b`, "size": "5", "x": "true"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"query": {"term": {"user": "a\"b"}}, "size": 5, "x": true}`, body)
+
+	body, _ = dsl.Render(map[string]string{"user": "u", "size": `1, "script": "x"`, "x": "y"})
+	assert.Equal(t, `{"query": {"term": {"user": "u"}}, "size": "1, \"script\": \"x\"", "x": "y"}`, body)
+}
+
+func Test_SavedQueryRenderEmbedded(t *testing.T) {
+	q := SavedQuery{Name: "like", Language: QuerySQL,
+		Text: `select * from logs where msg like '%{{term}}%' and note = 'it''s {{term}}' and tenant = 'acme' and u = "{{term}}"`}
+	sql, err := q.Render(map[string]string{"term": `' OR tenant != '`})
+	assert.NoError(t, err)
+	assert.Equal(t, `select * from logs where msg like '%'' OR tenant != ''%' and note = 'it''s '' OR tenant != ''' and tenant = 'acme' and u = "' OR tenant != '"`, sql)
+
+	sql, _ = q.Render(map[string]string{"term": `a\' "b`})
+	assert.Equal(t, `select * from logs where msg like '%a\\'' "b%' and note = 'it''s a\\'' "b' and tenant = 'acme' and u = "a\\' ""b"`, sql)
+
+	dsl := SavedQuery{Name: "prefix", Language: QueryDSL,
+		Text: `{"query": {"wildcard": {"host": "prefix-{{x}}*"}}, "q": "a \"{{x}}\" b", "size": {{x}}}`}
+	body, err := dsl.Render(map[string]string{"x": `", "script": "y`})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"query": {"wildcard": {"host": "prefix-\", \"script\": \"y*"}}, "q": "a \"\", \"script\": \"y\" b", "size": "\", \"script\": \"y"}`, body)
+}
+
+func Test_SavedQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esweb")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	DataDir = dir
+
+	q := SavedQuery{Name: "errors", Tags: []string{"ops"}, Language: QuerySQL, Text: "select * from logs where level = 'error'"}
+	assert.NoError(t, SaveQuery("prod", "", q))
+	assert.Error(t, SaveQuery("prod", "", q))
+	assert.Error(t, SaveQuery("prod", "", SavedQuery{Name: "../x", Language: QuerySQL, Text: "select 1"}))
+	assert.Error(t, SaveQuery("prod", "", SavedQuery{Name: "x", Language: "lucene", Text: "a:b"}))
+
+	list, err := ListSavedQueries("prod", "")
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	list, _ = ListSavedQueries("prod", "other")
+	assert.Len(t, list, 0)
+	list, _ = ListSavedQueries("dev", "")
+	assert.Len(t, list, 0)
+
+	q.Name = "all errors"
+	assert.NoError(t, SaveQuery("prod", "errors", q))
+	_, err = GetSavedQuery("prod", "errors")
+	assert.Error(t, err)
+	got, err := GetSavedQuery("prod", "all errors")
+	assert.NoError(t, err)
+	assert.False(t, got.Updated.IsZero())
+
+	added, replaced, skipped, err := ImportSavedQueries("prod", []SavedQuery{
+		{Name: "all errors", Language: QuerySQL, Text: "select 2"},
+		{Name: "slow", Language: QuerySQL, Text: "select 3"},
+	}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 0, 1}, []int{added, replaced, skipped})
+
+	_, replaced, _, err = ImportSavedQueries("prod", []SavedQuery{{Name: "all errors", Language: QuerySQL, Text: "select 2"}}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replaced)
+	got, _ = GetSavedQuery("prod", "all errors")
+	assert.Equal(t, "select 2", got.Text)
+
+	assert.NoError(t, DeleteSavedQuery("prod", "slow"))
+	assert.Error(t, DeleteSavedQuery("prod", "slow"))
+	list, _ = ListSavedQueries("prod", "")
+	assert.Len(t, list, 1)
+}
@@ -13,13 +13,36 @@ import (
 
 var DisablePrettyJSON = false
 
+// hitsTotal is hits.total, a number up to 6.x and an object with value and relation from 7.x on
+type hitsTotal int64
+
+func (t *hitsTotal) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*t = hitsTotal(n)
+		return nil
+	}
+
+	var o struct {
+		Value    int64  `json:"value"`
+		Relation string `json:"relation"`
+	}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+	*t = hitsTotal(o.Value)
+	return nil
+}
+
 type searchResponse struct {
+	cache CacheInfo
+
 	ScrollID string `json:"_scroll_id"`
 	Took     int    `json:"took"`
 	Timeout  int    `json:"time_out"`
 	Hits     struct {
-		MaxScore float64 `json:"max_score"`
-		Total    int     `json:"total"`
+		MaxScore float64   `json:"max_score"`
+		Total    hitsTotal `json:"total"`
 		Hits     []struct {
 			ID     string                 `json:"_id"`
 			Index  string                 `json:"_index"`
@@ -53,6 +76,11 @@ type Table struct {
 	Pagination *Pagination `json:"pagination,omitempty"`
 }
 
+// Cache tells whether the response came from the result cache
+func (r *searchResponse) Cache() CacheInfo {
+	return r.cache
+}
+
 func (r *searchResponse) IsEmpty() bool {
 	if r != nil {
 		return len(r.Hits.Hits) < 1
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SplitStatements(t *testing.T) {
+	script := `-- users
+SELECT * FROM users WHERE name = 'a;b' ;
+/* counts; per day */ SELECT count(*) FROM logs WHERE msg = "it's; fine";;
+SELECT * FROM logs WHERE path = 'c:\\x;' -- trailing; comment
+`
+	assert.Equal(t, []string{
+		"SELECT * FROM users WHERE name = 'a;b'",
+		`SELECT count(*) FROM logs WHERE msg = "it's; fine"`,
+		`SELECT * FROM logs WHERE path = 'c:\\x;'`,
+	}, SplitStatements(script))
+
+	assert.Equal(t, []string{"SELECT 1"}, SplitStatements("SELECT 1"))
+	assert.Empty(t, SplitStatements(" ; -- nothing\n"))
+	assert.Equal(t, []string{"SELECT 'it''s'", "SELECT 2"}, SplitStatements("SELECT 'it''s'; SELECT 2"))
+}
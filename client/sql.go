@@ -0,0 +1,308 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/ll2l/elasticsql"
+)
+
+// Engines translating SQL statements
+const (
+	SQLEngineAuto       = "auto"       // elasticsql, X-Pack SQL for the statements it cannot parse
+	SQLEngineElasticsql = "elasticsql" // converted to a search body by esweb
+	SQLEngineXPack      = "xpack"      // run by the SQL engine of the cluster
+)
+
+var (
+	// Rows fetched per request of an X-Pack SQL cursor
+	SQLFetchSize = 1000
+	// Rows kept of an X-Pack SQL result, the cursor is closed past them
+	SQLMaxRows = 10000
+)
+
+var sqlFromIndex = regexp.MustCompile(`(?i)\bFROM\s+"?([^\s,;"()]+)`)
+
+// Result is a query result which can be shown as a table
+type Result interface {
+	IsEmpty() bool
+	AsTableRows() *Table
+}
+
+// sqlResponse is a page of an X-Pack SQL result
+type sqlResponse struct {
+	Columns []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"columns"`
+	Rows   []Row  `json:"rows"`
+	Cursor string `json:"cursor"`
+}
+
+func (r *sqlResponse) IsEmpty() bool {
+	return r == nil || len(r.Rows) == 0
+}
+
+func (r *sqlResponse) AsTableRows() *Table {
+	t := Table{Columns: []string{}, Rows: []Row{}}
+	if r == nil {
+		return &t
+	}
+	for _, col := range r.Columns {
+		t.Columns = append(t.Columns, col.Name)
+	}
+	t.Rows = append(t.Rows, r.Rows...)
+	return &t
+}
+
+// Query runs a SQL statement with engine and returns the engine used
+func (c *Client) Query(sql, engine string) (Result, string, error) {
+	var (
+		dsl, index string
+		err        error
+	)
+
+	if engine != SQLEngineXPack {
+		dsl, index, engine, err = c.GetDsl(sql, engine)
+		if err != nil {
+			return nil, engine, err
+		}
+	}
+
+	if !c.hasHistoryRecord(sql) {
+		History[c.Alias] = append(History[c.Alias], newHistoryRecord(sql))
+	}
+
+	if engine == SQLEngineXPack {
+		start := time.Now()
+		res, err := c.SQLQuery(sql)
+		if err != nil {
+			return nil, engine, err
+		}
+
+		// X-Pack SQL reports no took time nor shards
+		roundTrip := int64(time.Since(start) / time.Millisecond)
+		recordQuery(QueryRecord{
+			Cluster:   c.Alias,
+			Index:     sqlIndex(sql),
+			Query:     sql,
+			Took:      roundTrip,
+			RoundTrip: roundTrip,
+			Hits:      int64(len(res.Rows)),
+		})
+		return res, engine, nil
+	}
+
+	res, err := c.Search(index, dsl)
+	if err != nil {
+		return nil, engine, err
+	}
+	return res, engine, nil
+}
+
+// GetDsl translates a SQL statement to a search body and returns the engine which
+// translated it. With SQLEngineAuto the statements elasticsql cannot parse are
+// translated by the cluster when it has X-Pack SQL.
+func (c *Client) GetDsl(sql, engine string) (dsl, index, used string, err error) {
+	switch engine {
+	case SQLEngineXPack:
+		dsl, err = c.TranslateSQL(sql)
+		return dsl, sqlIndex(sql), SQLEngineXPack, err
+	case SQLEngineElasticsql:
+		dsl, index, err = convertSQL(sql)
+		return dsl, index, SQLEngineElasticsql, err
+	case SQLEngineAuto, "":
+		dsl, index, err = convertSQL(sql)
+		if err == nil || !c.HasSQL() {
+			return dsl, index, SQLEngineElasticsql, err
+		}
+		var xerr error
+		if dsl, xerr = c.TranslateSQL(sql); xerr != nil {
+			return "", "", SQLEngineXPack, fmt.Errorf("elasticsql: %s\nx-pack sql: %s", err, xerr)
+		}
+		return dsl, sqlIndex(sql), SQLEngineXPack, nil
+	}
+	return "", "", engine, fmt.Errorf("unknown SQL engine %q", engine)
+}
+
+func convertSQL(sql string) (dsl, index string, err error) {
+	dsl, index, err = elasticsql.Convert(sql)
+	if err != nil {
+		return convertRetry(sql)
+	}
+	// elasticsql only converts SELECT and returns nothing for other statements
+	if dsl == "" {
+		return "", "", fmt.Errorf("unsupported statement: %s", sql)
+	}
+	return
+}
+
+// sqlIndex returns the index a SQL statement reads from
+func sqlIndex(sql string) string {
+	if m := sqlFromIndex.FindStringSubmatch(sql); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// sqlSupport caches whether a cluster has X-Pack SQL
+type sqlSupport struct {
+	mu        sync.Mutex
+	checked   bool
+	available bool
+}
+
+// HasSQL reports whether the cluster has the X-Pack SQL engine enabled. The answer is
+// kept for the connection once the cluster gave one, failed checks are tried again.
+func (c *Client) HasSQL() bool {
+	if c.sql == nil {
+		return false
+	}
+
+	c.sql.mu.Lock()
+	defer c.sql.mu.Unlock()
+
+	if c.sql.checked {
+		return c.sql.available
+	}
+	available, ok := c.probeSQL()
+	if ok {
+		c.sql.checked, c.sql.available = true, available
+	}
+	return available
+}
+
+// probeSQL asks the cluster whether it has X-Pack SQL, ok is false when it could not
+// tell, such as after a cancelled request or an unavailable node
+func (c *Client) probeSQL() (available, ok bool) {
+	if c.IsOpenSearch() {
+		return false, true
+	}
+
+	res, err := c.es.XPack.Info(c.es.XPack.Info.WithCategories("features"))
+	if err != nil {
+		return false, false
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == 400 || res.StatusCode == 404:
+		// Distributions without X-Pack have no info endpoint
+		return false, true
+	case res.IsError():
+		return false, false
+	}
+
+	var r struct {
+		Features struct {
+			SQL struct {
+				Available bool `json:"available"`
+				Enabled   bool `json:"enabled"`
+			} `json:"sql"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return false, false
+	}
+	return r.Features.SQL.Available && r.Features.SQL.Enabled, true
+}
+
+// TranslateSQL returns the search body the X-Pack SQL engine runs for a statement
+func (c *Client) TranslateSQL(sql string) (string, error) {
+	res, err := c.sqlRequest("/translate", map[string]interface{}{"query": sql})
+	if err := checkElasticResp(res, err); err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(res.Body); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SQLQuery runs a statement with the X-Pack SQL engine, following the cursor up to
+// SQLMaxRows rows
+func (c *Client) SQLQuery(sql string) (*sqlResponse, error) {
+	var result *sqlResponse
+	body := map[string]interface{}{"query": sql, "fetch_size": SQLFetchSize}
+	if timeout := c.searchTimeout(); timeout > 0 {
+		body["request_timeout"] = timeout.String()
+	}
+
+	for {
+		res, err := c.sqlRequest("", body)
+		if err := checkElasticResp(res, err); err != nil {
+			return nil, err
+		}
+
+		var page sqlResponse
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// Pages after the first one only have rows
+		if result == nil {
+			result = &page
+		} else {
+			result.Rows = append(result.Rows, page.Rows...)
+		}
+
+		if page.Cursor == "" {
+			return result, nil
+		}
+		if len(result.Rows) >= SQLMaxRows {
+			result.Rows = result.Rows[:SQLMaxRows]
+			c.closeSQLCursor(page.Cursor)
+			return result, nil
+		}
+		body = map[string]interface{}{"cursor": page.Cursor}
+	}
+}
+
+func (c *Client) closeSQLCursor(cursor string) {
+	res, err := c.sqlRequest("/close", map[string]interface{}{"cursor": cursor})
+	if err == nil {
+		res.Body.Close()
+	}
+}
+
+// sqlRequest posts to the SQL API, which is under _xpack up to 6.x
+func (c *Client) sqlRequest(path string, body interface{}) (*esapi.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := "/_sql"
+	if c.MajorVersion() < 7 {
+		prefix = "/_xpack/sql"
+	}
+
+	// Only queries take a format, translate and close reject it
+	url := prefix + path
+	if path == "" {
+		url += "?format=json"
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.es.Perform(req)
+	if err != nil {
+		return nil, err
+	}
+	return &esapi.Response{StatusCode: res.StatusCode, Body: res.Body, Header: res.Header}, nil
+}
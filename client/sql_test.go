@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_sqlIndex(t *testing.T) {
+	assert.Equal(t, "logs-*", sqlIndex(`SELECT user FROM logs-* WHERE x = 1`))
+	assert.Equal(t, "logs", sqlIndex(`select count(*) from "logs" group by user`))
+	assert.Equal(t, "", sqlIndex(`SHOW TABLES`))
+}
+
+func Test_HasSQL(t *testing.T) {
+	infos := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.2"}}`))
+		case "/_xpack":
+			if infos++; infos == 1 {
+				w.WriteHeader(500)
+				w.Write([]byte(`{"error":"failed"}`))
+				return
+			}
+			w.Write([]byte(`{"features":{"sql":{"available":true,"enabled":true}}}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+
+	// Neither a cancelled request nor an error is kept as the answer
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, cl.WithContext(ctx, "", 0).HasSQL())
+	assert.False(t, cl.HasSQL())
+	assert.True(t, cl.HasSQL())
+	assert.True(t, cl.HasSQL())
+	assert.Equal(t, 2, infos)
+}
+
+func Test_SQLQuery(t *testing.T) {
+	var closed string
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		paths = append(paths, r.URL.Path)
+
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.2"}}`))
+		case "/_xpack":
+			w.Write([]byte(`{"features":{"sql":{"available":true,"enabled":true}}}`))
+		case "/_sql/translate":
+			w.Write([]byte(`{"size":0,"aggregations":{"groupby":{"composite":{}}}}`))
+		case "/_sql/close":
+			closed, _ = body["cursor"].(string)
+			w.Write([]byte(`{"succeeded":true}`))
+		case "/_sql":
+			switch body["cursor"] {
+			case nil:
+				w.Write([]byte(`{"columns":[{"name":"user","type":"keyword"},{"name":"n","type":"long"}],
+					"rows":[["a",1],["b",2]],"cursor":"c1"}`))
+			case "c1":
+				w.Write([]byte(`{"rows":[["c",3],["d",4]],"cursor":"c2"}`))
+			default:
+				w.Write([]byte(`{"rows":[["e",5]]}`))
+			}
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+	assert.True(t, cl.HasSQL())
+
+	res, engine, err := cl.Query("SELECT user, COUNT(*) AS n FROM logs GROUP BY user HAVING n > 0", SQLEngineXPack)
+	assert.NoError(t, err)
+	assert.Equal(t, SQLEngineXPack, engine)
+	table := res.AsTableRows()
+	assert.Equal(t, []string{"user", "n"}, table.Columns)
+	assert.Len(t, table.Rows, 5)
+	assert.Equal(t, "", closed)
+
+	defer func(max int) { SQLMaxRows = max }(SQLMaxRows)
+	SQLMaxRows = 3
+	res, _, err = cl.Query("SELECT user, COUNT(*) AS n FROM logs GROUP BY user", SQLEngineXPack)
+	assert.NoError(t, err)
+	assert.Len(t, res.AsTableRows().Rows, 3)
+	assert.Equal(t, "c2", closed)
+
+	dsl, index, engine, err := cl.GetDsl("SHOW TABLES", SQLEngineAuto)
+	assert.NoError(t, err)
+	assert.Equal(t, SQLEngineXPack, engine)
+	assert.Equal(t, "", index)
+	assert.Contains(t, dsl, "composite")
+
+	_, index, engine, err = cl.GetDsl("SELECT * FROM logs WHERE user = 'a'", SQLEngineAuto)
+	assert.NoError(t, err)
+	assert.Equal(t, SQLEngineElasticsql, engine)
+	assert.Equal(t, "logs", index)
+
+	_, _, _, err = cl.GetDsl("SELECT 1", "sqlite")
+	assert.Error(t, err)
+}
@@ -0,0 +1,356 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// SlowQueryThreshold is the time in the cluster from which queries go to the slow
+	// log, 0 disables the slow log
+	SlowQueryThreshold time.Duration
+	// SlowLogFile is the JSON lines file of the slow queries
+	SlowLogFile string
+	// SlowLogMaxBytes is the size from which the slow log is moved to SlowLogFile.1,
+	// replacing the previous one, 0 for no limit
+	SlowLogMaxBytes int64 = 16 << 20
+)
+
+const (
+	// Queries longer than this are cut in the records
+	maxRecordedQuery = 4096
+	// Durations kept per query shape for the percentiles
+	shapeSamples = 500
+	// Query shapes kept, the least recently seen one is dropped past it
+	maxShapes = 1000
+)
+
+var (
+	sqlLiteral = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|\b\d+(?:\.\d+)?\b`)
+	spaces     = regexp.MustCompile(`\s+`)
+)
+
+// QueryRecord is a query run on a cluster
+type QueryRecord struct {
+	Time      time.Time   `json:"time"`
+	Cluster   string      `json:"cluster"`
+	Index     string      `json:"index"`
+	Query     string      `json:"query"`
+	Shape     string      `json:"shape"`
+	Took      int64       `json:"took_ms"` // Time in the cluster, the round trip for X-Pack SQL which does not report it
+	RoundTrip int64       `json:"round_trip_ms"`
+	Shards    ShardCounts `json:"shards"`
+	Hits      int64       `json:"hits"`
+	TimedOut  bool        `json:"timed_out,omitempty"`
+}
+
+// ShardCounts are the shards a search ran on
+type ShardCounts struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+}
+
+// QueryStats are the statistics of the queries of a shape
+type QueryStats struct {
+	Cluster string    `json:"cluster"`
+	Index   string    `json:"index"`
+	Shape   string    `json:"shape"`
+	Count   int64     `json:"count"`
+	Total   int64     `json:"total_ms"`
+	P50     int64     `json:"p50_ms"`
+	P95     int64     `json:"p95_ms"`
+	Max     int64     `json:"max_ms"`
+	Last    time.Time `json:"last"`
+}
+
+type shapeStats struct {
+	QueryStats
+	samples []int64 // Ring of the latest durations
+	next    int
+}
+
+var (
+	statsMu sync.Mutex
+	stats   = map[string]*shapeStats{}
+
+	slowMu sync.Mutex
+)
+
+// QueryShape returns a query with its values replaced by ?, queries which differ only
+// by their values have the same shape
+func QueryShape(query string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(query), &v); err == nil {
+		b, _ := json.Marshal(shapeOf(v))
+		return string(b)
+	}
+	return strings.TrimSpace(spaces.ReplaceAllString(sqlLiteral.ReplaceAllString(query, "?"), " "))
+}
+
+func shapeOf(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			m[k] = shapeOf(x)
+		}
+		return m
+	case []interface{}:
+		// Lists of values, as in terms, have the shape of one value
+		items := []interface{}{}
+		seen := map[string]bool{}
+		for _, x := range t {
+			s := shapeOf(x)
+			b, _ := json.Marshal(s)
+			if !seen[string(b)] {
+				seen[string(b)] = true
+				items = append(items, s)
+			}
+		}
+		return items
+	}
+	return "?"
+}
+
+// recordQuery adds a query to the statistics and to the slow log when it is slow
+func recordQuery(r QueryRecord) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Shape = QueryShape(r.Query)
+	if len(r.Query) > maxRecordedQuery {
+		r.Query = r.Query[:maxRecordedQuery]
+	}
+
+	addStats(r)
+	if SlowQueryThreshold > 0 && time.Duration(r.Took)*time.Millisecond >= SlowQueryThreshold {
+		if err := writeSlowLog(r); err != nil {
+			log.Printf("slow log: %s", err)
+		}
+	}
+}
+
+func addStats(r QueryRecord) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	key := strings.Join([]string{r.Cluster, r.Index, r.Shape}, "\x00")
+	s, ok := stats[key]
+	if !ok {
+		if len(stats) >= maxShapes {
+			dropOldestShape()
+		}
+		s = &shapeStats{QueryStats: QueryStats{Cluster: r.Cluster, Index: r.Index, Shape: r.Shape}}
+		stats[key] = s
+	}
+
+	s.Count++
+	s.Total += r.Took
+	if r.Took > s.Max {
+		s.Max = r.Took
+	}
+	s.Last = r.Time
+
+	if len(s.samples) < shapeSamples {
+		s.samples = append(s.samples, r.Took)
+	} else {
+		s.samples[s.next] = r.Took
+		s.next = (s.next + 1) % shapeSamples
+	}
+}
+
+func dropOldestShape() {
+	var oldest string
+	for k, s := range stats {
+		if oldest == "" || s.Last.Before(stats[oldest].Last) {
+			oldest = k
+		}
+	}
+	delete(stats, oldest)
+}
+
+// percentile returns the p-th percentile of sorted durations, nearest rank
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (p*len(sorted)+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// GetQueryStats returns the statistics by query shape of a cluster, all clusters when
+// it is empty, the shapes taking the most time in the cluster first. The percentiles
+// are of the latest queries of each shape.
+func GetQueryStats(cluster string) []QueryStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	list := []QueryStats{}
+	for _, s := range stats {
+		if cluster != "" && s.Cluster != cluster {
+			continue
+		}
+
+		sorted := append([]int64(nil), s.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		qs := s.QueryStats
+		qs.P50 = percentile(sorted, 50)
+		qs.P95 = percentile(sorted, 95)
+		list = append(list, qs)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total != list[j].Total {
+			return list[i].Total > list[j].Total
+		}
+		return list[i].Shape < list[j].Shape
+	})
+	return list
+}
+
+// ResetQueryStats forgets the statistics of a cluster, of all clusters when it is empty
+func ResetQueryStats(cluster string) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	for k, s := range stats {
+		if cluster == "" || s.Cluster == cluster {
+			delete(stats, k)
+		}
+	}
+}
+
+func writeSlowLog(r QueryRecord) error {
+	if SlowLogFile == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	slowMu.Lock()
+	defer slowMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(SlowLogFile), 0700); err != nil {
+		return err
+	}
+	if info, err := os.Stat(SlowLogFile); err == nil && SlowLogMaxBytes > 0 && info.Size()+int64(len(line)) >= SlowLogMaxBytes {
+		if err := os.Rename(SlowLogFile, SlowLogFile+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(SlowLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadSlowLog returns the latest slow queries of a cluster, all clusters when it is
+// empty, newest first. The log is read from its end, up to limit queries when it is
+// greater than 0.
+func ReadSlowLog(cluster string, limit int) ([]QueryRecord, error) {
+	records := []QueryRecord{}
+	if SlowLogFile == "" {
+		return records, nil
+	}
+
+	// The files stay readable once open, writes and rotations do not wait for the read
+	slowMu.Lock()
+	var files []*os.File
+	for _, name := range []string{SlowLogFile, SlowLogFile + ".1"} {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			slowMu.Unlock()
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	slowMu.Unlock()
+	defer closeFiles(files)
+
+	for _, f := range files {
+		err := eachLineBackward(f, func(line []byte) bool {
+			var r QueryRecord
+			if json.Unmarshal(line, &r) != nil || (cluster != "" && r.Cluster != cluster) {
+				return true
+			}
+			records = append(records, r)
+			return limit <= 0 || len(records) < limit
+		})
+		if err != nil {
+			return nil, err
+		}
+		if limit > 0 && len(records) >= limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// eachLineBackward calls fn with the lines of a file from the last one, until fn
+// returns false
+func eachLineBackward(f *os.File, fn func(line []byte) bool) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	const chunk = 64 * 1024
+	var head []byte // Start of a line continued in the chunk after
+	for off := info.Size(); off > 0; {
+		n := int64(chunk)
+		if off < n {
+			n = off
+		}
+		off -= n
+
+		buf := make([]byte, n, n+int64(len(head)))
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return err
+		}
+		lines := bytes.Split(append(buf, head...), []byte{'\n'})
+
+		// The first line may begin in the chunk before
+		head = lines[0]
+		for i := len(lines) - 1; i > 0; i-- {
+			if len(lines[i]) > 0 && !fn(lines[i]) {
+				return nil
+			}
+		}
+	}
+	if len(head) > 0 {
+		fn(head)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_QueryShape(t *testing.T) {
+	assert.Equal(t, "SELECT * FROM logs WHERE status = ? AND user = ? LIMIT ?",
+		QueryShape("SELECT *  FROM logs\nWHERE status = 404 AND user = 'o''brien' LIMIT 10"))
+	assert.Equal(t, QueryShape(`{"query":{"term":{"status":404}}}`), QueryShape(`{"query": {"term": {"status": 500}}}`))
+	assert.Equal(t, `{"query":{"terms":{"status":["?"]}}}`, QueryShape(`{"query":{"terms":{"status":[404,500,503]}}}`))
+	assert.NotEqual(t, QueryShape(`{"query":{"term":{"status":404}}}`), QueryShape(`{"query":{"term":{"code":404}}}`))
+}
+
+func Test_percentile(t *testing.T) {
+	sorted := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, int64(5), percentile(sorted, 50))
+	assert.Equal(t, int64(10), percentile(sorted, 95))
+	assert.Equal(t, int64(7), percentile([]int64{7}, 50))
+	assert.Equal(t, int64(0), percentile(nil, 95))
+}
+
+func Test_GetQueryStats(t *testing.T) {
+	defer ResetQueryStats("stats")
+
+	for i := int64(1); i <= 20; i++ {
+		addStats(QueryRecord{Cluster: "stats", Index: "logs", Shape: "a", Took: i, Time: time.Now()})
+	}
+	addStats(QueryRecord{Cluster: "stats", Index: "logs", Shape: "b", Took: 500, Time: time.Now()})
+	addStats(QueryRecord{Cluster: "other", Index: "logs", Shape: "a", Took: 1, Time: time.Now()})
+	defer ResetQueryStats("other")
+
+	stats := GetQueryStats("stats")
+	if assert.Len(t, stats, 2) {
+		assert.Equal(t, "b", stats[0].Shape)
+		assert.Equal(t, int64(1), stats[0].Count)
+
+		a := stats[1]
+		assert.Equal(t, int64(20), a.Count)
+		assert.Equal(t, int64(210), a.Total)
+		assert.Equal(t, int64(10), a.P50)
+		assert.Equal(t, int64(19), a.P95)
+		assert.Equal(t, int64(20), a.Max)
+	}
+
+	ResetQueryStats("stats")
+	assert.Empty(t, GetQueryStats("stats"))
+	assert.Len(t, GetQueryStats("other"), 1)
+}
+
+func Test_SlowLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esweb-slowlog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(threshold time.Duration, file string) {
+		SlowQueryThreshold, SlowLogFile = threshold, file
+	}(SlowQueryThreshold, SlowLogFile)
+	SlowQueryThreshold = 100 * time.Millisecond
+	SlowLogFile = filepath.Join(dir, "logs", "slow.log")
+	defer ResetQueryStats("slow")
+
+	recordQuery(QueryRecord{Cluster: "slow", Index: "logs", Query: "SELECT * FROM logs WHERE a = 1", Took: 50})
+	recordQuery(QueryRecord{Cluster: "slow", Index: "logs", Query: "SELECT * FROM logs WHERE a = 2", Took: 150})
+	recordQuery(QueryRecord{Cluster: "slow", Index: "logs", Query: "SELECT * FROM logs WHERE a = 3", Took: 300})
+	recordQuery(QueryRecord{Cluster: "other", Index: "logs", Query: "SELECT 1", Took: 300})
+	defer ResetQueryStats("other")
+
+	records, err := ReadSlowLog("slow", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, int64(300), records[0].Took)
+		assert.Equal(t, "SELECT * FROM logs WHERE a = ?", records[0].Shape)
+	}
+
+	records, err = ReadSlowLog("", 1)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "other", records[0].Cluster)
+	}
+
+	stats := GetQueryStats("slow")
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, int64(3), stats[0].Count)
+	}
+}
+
+func Test_SlowLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esweb-slowlog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(threshold time.Duration, file string, max int64) {
+		SlowQueryThreshold, SlowLogFile, SlowLogMaxBytes = threshold, file, max
+	}(SlowQueryThreshold, SlowLogFile, SlowLogMaxBytes)
+	SlowQueryThreshold = time.Millisecond
+	SlowLogFile = filepath.Join(dir, "slow.log")
+	SlowLogMaxBytes = 1024
+	defer ResetQueryStats("rotated")
+
+	for i := int64(1); i <= 50; i++ {
+		recordQuery(QueryRecord{Cluster: "rotated", Index: "logs", Query: "SELECT * FROM logs", Took: i})
+	}
+
+	for _, name := range []string{SlowLogFile, SlowLogFile + ".1"} {
+		info, err := os.Stat(name)
+		if assert.NoError(t, err) {
+			assert.True(t, info.Size() <= SlowLogMaxBytes)
+		}
+	}
+
+	records, err := ReadSlowLog("rotated", 0)
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, records) {
+		assert.True(t, len(records) < 50)
+		for i, r := range records {
+			assert.Equal(t, int64(50-i), r.Took)
+		}
+	}
+}
+
+func Test_eachLineBackward(t *testing.T) {
+	f, err := ioutil.TempFile("", "esweb-lines")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var want []string
+	for i := 0; i < 3000; i++ {
+		line := fmt.Sprintf("line %d %s", i, strings.Repeat("x", i%100))
+		want = append([]string{line}, want...)
+		f.WriteString(line + "\n")
+	}
+
+	var got []string
+	assert.NoError(t, eachLineBackward(f, func(line []byte) bool {
+		got = append(got, string(line))
+		return true
+	}))
+	assert.Equal(t, want, got)
+}
+
+func Test_recordSearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"6.8.5"}}`))
+		case "/logs/_search":
+			w.Write([]byte(`{"took":12,"timed_out":false,"_shards":{"total":5,"successful":4,"skipped":0,"failed":1},"hits":{"total":3,"hits":[]}}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+	cl.Alias = "recorded"
+	defer ResetQueryStats("recorded")
+
+	_, _, err = cl.SearchWithBody("logs", `{"query":{"term":{"status":404}}}`)
+	assert.NoError(t, err)
+	_, err = cl.Search("logs", `{"query":{"term":{"status":500}}}`)
+	assert.NoError(t, err)
+
+	stats := GetQueryStats("recorded")
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, "logs", stats[0].Index)
+		assert.Equal(t, `{"query":{"term":{"status":"?"}}}`, stats[0].Shape)
+		assert.Equal(t, int64(2), stats[0].Count)
+		assert.Equal(t, int64(12), stats[0].Max)
+	}
+}
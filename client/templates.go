@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+)
+
+// SearchTemplate is a stored script of the mustache language
+type SearchTemplate struct {
+	ID     string   `json:"id"`
+	Source string   `json:"source"`
+	Params []string `json:"params"`
+}
+
+var (
+	mustacheVar    = regexp.MustCompile(`\{\{\{?\s*[#^]?\s*([\w.]+)\s*\}?\}\}`)
+	mustacheLambda = regexp.MustCompile(`\{\{#(?:toJson|join)\}\}\s*([\w.]+)\s*\{\{/`)
+
+	// Functions of the mustache language, not params
+	mustacheBuiltins = map[string]bool{"toJson": true, "join": true, "url": true}
+)
+
+// mustacheParams returns the variables used by a template, sorted
+func mustacheParams(source string) []string {
+	seen := map[string]bool{}
+	params := []string{}
+	add := func(matches [][]string) {
+		for _, m := range matches {
+			if !mustacheBuiltins[m[1]] && !seen[m[1]] {
+				seen[m[1]] = true
+				params = append(params, m[1])
+			}
+		}
+	}
+	add(mustacheVar.FindAllStringSubmatch(source, -1))
+	add(mustacheLambda.FindAllStringSubmatch(source, -1))
+	sort.Strings(params)
+	return params
+}
+
+// scriptSource returns the source of a stored script, which is a string or an object
+func scriptSource(src interface{}) string {
+	if s, ok := src.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(src)
+	return string(b)
+}
+
+// SearchTemplates lists the stored mustache scripts of the cluster
+func (c *Client) SearchTemplates() ([]SearchTemplate, error) {
+	res, err := c.es.Cluster.State(
+		c.es.Cluster.State.WithMetric("metadata"),
+		c.es.Cluster.State.WithFilterPath("metadata.stored_scripts"),
+	)
+	if err := checkElasticResp(res, err); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var r struct {
+		Metadata struct {
+			StoredScripts map[string]struct {
+				Lang   string      `json:"lang"`
+				Source interface{} `json:"source"`
+			} `json:"stored_scripts"`
+		} `json:"metadata"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	templates := []SearchTemplate{}
+	for id, s := range r.Metadata.StoredScripts {
+		if s.Lang != "mustache" {
+			continue
+		}
+		src := scriptSource(s.Source)
+		templates = append(templates, SearchTemplate{ID: id, Source: src, Params: mustacheParams(src)})
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].ID < templates[j].ID })
+	return templates, nil
+}
+
+// GetSearchTemplate returns a stored mustache script
+func (c *Client) GetSearchTemplate(id string) (SearchTemplate, error) {
+	res, err := c.es.GetScript(id)
+	if err := checkElasticResp(res, err); err != nil {
+		return SearchTemplate{}, err
+	}
+	defer res.Body.Close()
+
+	var r struct {
+		Found  bool `json:"found"`
+		Script struct {
+			Lang   string      `json:"lang"`
+			Source interface{} `json:"source"`
+		} `json:"script"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return SearchTemplate{}, err
+	}
+	if !r.Found || r.Script.Lang != "mustache" {
+		return SearchTemplate{}, fmt.Errorf("search template %s not found", id)
+	}
+
+	src := scriptSource(r.Script.Source)
+	return SearchTemplate{ID: id, Source: src, Params: mustacheParams(src)}, nil
+}
+
+// ScriptConflictError is returned when saving a search template would replace a stored
+// script of another language
+type ScriptConflictError struct {
+	ID   string
+	Lang string
+}
+
+func (e *ScriptConflictError) Error() string {
+	return fmt.Sprintf("%s is a stored %s script, confirm to replace it with the template", e.ID, e.Lang)
+}
+
+// PutSearchTemplate creates or replaces a stored mustache script. A stored script of
+// another language is only replaced with overwrite.
+func (c *Client) PutSearchTemplate(id, source string, overwrite bool) error {
+	if id == "" || source == "" {
+		return fmt.Errorf("a search template needs an id and a source")
+	}
+
+	if !overwrite {
+		lang, err := c.storedScriptLang(id)
+		if err != nil {
+			return err
+		}
+		if lang != "" && lang != "mustache" {
+			return &ScriptConflictError{ID: id, Lang: lang}
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{"lang": "mustache", "source": source},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := c.es.PutScript(id, bytes.NewReader(body))
+	if err := checkElasticResp(res, err); err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// storedScriptLang returns the language of a stored script, empty when there is none
+func (c *Client) storedScriptLang(id string) (string, error) {
+	res, err := c.es.GetScript(id)
+	if err == nil && res.StatusCode == 404 {
+		res.Body.Close()
+		return "", nil
+	}
+	if err := checkElasticResp(res, err); err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var r struct {
+		Script struct {
+			Lang string `json:"lang"`
+		} `json:"script"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return "", err
+	}
+	return r.Script.Lang, nil
+}
+
+// DeleteSearchTemplate removes a stored mustache script
+func (c *Client) DeleteSearchTemplate(id string) error {
+	if _, err := c.GetSearchTemplate(id); err != nil {
+		return err
+	}
+
+	res, err := c.es.DeleteScript(id)
+	if err := checkElasticResp(res, err); err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// RenderSearchTemplate returns the search body a stored template expands to
+func (c *Client) RenderSearchTemplate(id string, params map[string]interface{}) (map[string]interface{}, error) {
+	body, err := templateBody(id, params)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.es.RenderSearchTemplate(c.es.RenderSearchTemplate.WithBody(body))
+	if err := checkElasticResp(res, err); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var r struct {
+		Output map[string]interface{} `json:"template_output"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	return r.Output, nil
+}
+
+// RunSearchTemplate searches index with a stored template
+func (c *Client) RunSearchTemplate(index, id string, params map[string]interface{}) (*searchResponse, error) {
+	body, err := templateBody(id, params)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []func(*esapi.SearchTemplateRequest){}
+	if index != "" {
+		opts = append(opts, c.es.SearchTemplate.WithIndex(index))
+	}
+
+	res, err := c.es.SearchTemplate(body, opts...)
+	if err := checkElasticResp(res, err); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var r searchResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func templateBody(id string, params map[string]interface{}) (*bytes.Reader, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	b, err := json.Marshal(map[string]interface{}{"id": id, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_mustacheParams(t *testing.T) {
+	src := `{"query": {"bool": {"must": {"match": {"{{field}}": "{{{value}}}"}}, "filter": {{#toJson}}filters{{/toJson}} }},
+		{{#size}}"size": {{size}},{{/size}} "from": {{ from }}, "q": "{{#url}}{{q}}{{/url}}"}`
+	assert.Equal(t, []string{"field", "filters", "from", "q", "size", "value"}, mustacheParams(src))
+}
+
+func Test_RunSearchTemplate(t *testing.T) {
+	var path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		b, _ := ioutil.ReadAll(r.Body)
+		path, body = r.URL.Path, string(b)
+
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.2"}}`))
+		case "/_cluster/state/metadata":
+			w.Write([]byte(`{"metadata":{"stored_scripts":{
+				"by_user":{"lang":"mustache","source":"{\"query\":{\"term\":{\"user\":\"{{user}}\"}}}"},
+				"calc":{"lang":"painless","source":"doc.x.value"}}}}`))
+		case "/_render/template":
+			w.Write([]byte(`{"template_output":{"query":{"term":{"user":"kimchy"}}}}`))
+		case "/logs/_search/template":
+			w.Write([]byte(`{"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"1","_source":{"user":"kimchy"}}]}}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+
+	templates, err := cl.SearchTemplates()
+	assert.NoError(t, err)
+	if assert.Len(t, templates, 1) {
+		assert.Equal(t, "by_user", templates[0].ID)
+		assert.Equal(t, []string{"user"}, templates[0].Params)
+	}
+
+	out, err := cl.RenderSearchTemplate("by_user", map[string]interface{}{"user": "kimchy"})
+	assert.NoError(t, err)
+	assert.Contains(t, out, "query")
+	assert.JSONEq(t, `{"id":"by_user","params":{"user":"kimchy"}}`, body)
+
+	res, err := cl.RunSearchTemplate("logs", "by_user", map[string]interface{}{"user": "kimchy"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/logs/_search/template", path)
+	assert.False(t, res.IsEmpty())
+	assert.Len(t, res.AsTableRows().Rows, 1)
+}
+
+func Test_PutSearchTemplate(t *testing.T) {
+	var puts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "PUT" {
+			puts = append(puts, r.URL.Path)
+			w.Write([]byte(`{"acknowledged":true}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.2"}}`))
+		case "/_scripts/calc":
+			w.Write([]byte(`{"_id":"calc","found":true,"script":{"lang":"painless","source":"doc.x.value"}}`))
+		case "/_scripts/by_user":
+			w.Write([]byte(`{"_id":"by_user","found":true,"script":{"lang":"mustache","source":"{}"}}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"_id":"new","found":false}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+
+	source := `{"query":{"match_all":{}}}`
+	assert.NoError(t, cl.PutSearchTemplate("new", source, false))
+	assert.NoError(t, cl.PutSearchTemplate("by_user", source, false))
+
+	err = cl.PutSearchTemplate("calc", source, false)
+	if assert.IsType(t, &ScriptConflictError{}, err) {
+		assert.Contains(t, err.Error(), "painless")
+	}
+	assert.Equal(t, []string{"/_scripts/new", "/_scripts/by_user"}, puts)
+
+	assert.NoError(t, cl.PutSearchTemplate("calc", source, true))
+	assert.Len(t, puts, 3)
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	minBatchSize      = 10
+	minBackoff        = 500 * time.Millisecond
+	maxBackoff        = 30 * time.Second
+	rampUpAfter       = 3 // successful bulk requests before the batch grows again
+	throughputPeriod  = 5 * time.Second
+	throughputChanged = 0.1 // relative change of docs/sec worth reporting
+)
+
+// throttle adapts the bulk batch size shared by all bulk workers to the
+// destination's pressure: it shrinks the batch and backs off when the
+// destination rejects requests and ramps back up while it is healthy.
+type throttle struct {
+	mu         sync.Mutex
+	max        int
+	batch      int
+	backoff    time.Duration
+	streak     int
+	rejections int64
+}
+
+func newThrottle(batch int) *throttle {
+	return &throttle{max: batch, batch: batch}
+}
+
+// Batch returns the current bulk batch size
+func (t *throttle) Batch() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.batch
+}
+
+// Rejections returns how many times the destination pushed back
+func (t *throttle) Rejections() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rejections
+}
+
+// reject shrinks the batch and returns how long to wait before sending again
+func (t *throttle) reject() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rejections++
+	t.streak = 0
+	// Batches configured below minBatchSize are not raised to it
+	floor := minBatchSize
+	if t.max < floor {
+		floor = t.max
+	}
+	if t.batch /= 2; t.batch < floor {
+		t.batch = floor
+	}
+	if t.backoff *= 2; t.backoff < minBackoff {
+		t.backoff = minBackoff
+	}
+	if t.backoff > maxBackoff {
+		t.backoff = maxBackoff
+	}
+	return t.backoff
+}
+
+// success grows the batch back after a few healthy requests in a row
+func (t *throttle) success() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.backoff = 0
+	t.streak++
+	if t.streak >= rampUpAfter && t.batch < t.max {
+		t.streak = 0
+		if t.batch *= 2; t.batch > t.max {
+			t.batch = t.max
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_throttle(t *testing.T) {
+	th := newThrottle(100)
+
+	assert.Equal(t, minBackoff, th.reject())
+	assert.Equal(t, 50, th.Batch())
+	assert.Equal(t, 2*minBackoff, th.reject())
+	assert.Equal(t, 25, th.Batch())
+
+	for i := 0; i < 10; i++ {
+		th.reject()
+	}
+	assert.Equal(t, minBatchSize, th.Batch())
+	assert.Equal(t, int64(12), th.Rejections())
+
+	for i := 0; i < rampUpAfter*10; i++ {
+		th.success()
+	}
+	assert.Equal(t, 100, th.Batch())
+	assert.Equal(t, minBackoff, th.reject())
+
+	small := newThrottle(4)
+	small.reject()
+	assert.Equal(t, 4, small.Batch())
+}
@@ -0,0 +1,286 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transform describes the changes applied to every document during a migration.
+// Field names may use dots to address fields of nested objects.
+type Transform struct {
+	Include []string               `json:"include"` // Fields to keep, all fields when empty
+	Exclude []string               `json:"exclude"` // Fields to drop
+	Rename  map[string]string      `json:"rename"`  // Old field name -> new field name
+	Convert map[string]string      `json:"convert"` // Field name -> target type
+	Set     map[string]interface{} `json:"set"`     // Constant fields injected into every document
+}
+
+// Supported conversion targets for Transform.Convert
+var convertTypes = map[string]bool{
+	"string":       true,
+	"number":       true,
+	"integer":      true,
+	"boolean":      true,
+	"date":         true,
+	"epoch_millis": true,
+	"epoch_second": true,
+}
+
+// Validate checks the transform before a migration starts
+func (t *Transform) Validate() error {
+	if t == nil {
+		return nil
+	}
+	for field, typ := range t.Convert {
+		if !convertTypes[typ] {
+			return fmt.Errorf("unsupported conversion type %q for field %s", typ, field)
+		}
+	}
+	for from, to := range t.Rename {
+		if from == "" || to == "" {
+			return fmt.Errorf("invalid rename %q -> %q", from, to)
+		}
+	}
+	return nil
+}
+
+// IsEmpty returns true when the transform leaves documents unchanged
+func (t *Transform) IsEmpty() bool {
+	return t == nil || (len(t.Include) == 0 && len(t.Exclude) == 0 && len(t.Rename) == 0 &&
+		len(t.Convert) == 0 && len(t.Set) == 0)
+}
+
+// Apply returns a transformed copy of the document source. Include is applied
+// first, then exclude, rename, convert and finally the constant fields. Renames,
+// conversions and constant fields are applied in the order of their field names so
+// that chained renames such as a -> b, b -> c always give the same document.
+func (t *Transform) Apply(src map[string]interface{}) (map[string]interface{}, error) {
+	if t.IsEmpty() {
+		return src, nil
+	}
+
+	doc := make(map[string]interface{})
+	if len(t.Include) > 0 {
+		for _, f := range t.Include {
+			if v, ok := getField(src, f); ok {
+				setField(doc, f, copyValue(v))
+			}
+		}
+	} else {
+		doc = copyValue(src).(map[string]interface{})
+	}
+
+	for _, f := range t.Exclude {
+		deleteField(doc, f)
+	}
+
+	for _, from := range sortedKeys(t.Rename) {
+		to := t.Rename[from]
+		if v, ok := getField(doc, from); ok {
+			deleteField(doc, from)
+			setField(doc, to, v)
+		}
+	}
+
+	for _, f := range sortedKeys(t.Convert) {
+		typ := t.Convert[f]
+		v, ok := getField(doc, f)
+		if !ok || v == nil {
+			continue
+		}
+		cv, err := convertValue(v, typ)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", f, err)
+		}
+		setField(doc, f, cv)
+	}
+
+	set := make([]string, 0, len(t.Set))
+	for f := range t.Set {
+		set = append(set, f)
+	}
+	sort.Strings(set)
+	for _, f := range set {
+		setField(doc, f, t.Set[f])
+	}
+
+	return doc, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func getField(doc map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for i, p := range parts {
+		v, ok := cur[p]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return v, true
+		}
+		if cur, ok = v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func setField(doc map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[p] = next
+		}
+		cur = next
+	}
+	cur[parts[len(parts)-1]] = value
+}
+
+func deleteField(doc map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(map[string]interface{})
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, parts[len(parts)-1])
+}
+
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			m[k] = copyValue(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, item := range t {
+			s[i] = copyValue(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func convertValue(v interface{}, typ string) (interface{}, error) {
+	if s, ok := v.([]interface{}); ok {
+		res := make([]interface{}, len(s))
+		for i, item := range s {
+			cv, err := convertValue(item, typ)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = cv
+		}
+		return res, nil
+	}
+
+	switch typ {
+	case "string":
+		return fmt.Sprintf("%v", v), nil
+	case "number":
+		return toFloat(v)
+	case "integer":
+		f, err := toFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		return int64(f), nil
+	case "boolean":
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(b))
+		case float64:
+			return b != 0, nil
+		}
+	case "date":
+		// epoch millis -> RFC3339 date
+		if s, ok := v.(string); ok {
+			if _, err := time.Parse(time.RFC3339, s); err == nil {
+				return s, nil
+			}
+		}
+		f, err := toFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(0, int64(f)*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), nil
+	case "epoch_millis", "epoch_second":
+		// Numbers are epoch millis, as for date
+		var ts time.Time
+		switch d := v.(type) {
+		case float64:
+			ts = time.Unix(0, int64(d)*int64(time.Millisecond))
+		case string:
+			var err error
+			if ts, err = parseDate(d); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("cannot convert %T to %s", v, typ)
+		}
+		if typ == "epoch_second" {
+			return ts.Unix(), nil
+		}
+		return ts.UnixNano() / int64(time.Millisecond), nil
+	}
+	return nil, fmt.Errorf("cannot convert %v to %s", v, typ)
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	}
+	return 0, fmt.Errorf("cannot convert %T to number", v)
+}
+
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", s)
+}
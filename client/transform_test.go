@@ -0,0 +1,67 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TransformApply(t *testing.T) {
+	src := map[string]interface{}{
+		"name":    "foo",
+		"price":   "12.5",
+		"created": float64(1577836800000),
+		"secret":  "x",
+		"user":    map[string]interface{}{"id": "7", "email": "a@b.c"},
+	}
+
+	tr := &Transform{
+		Exclude: []string{"secret", "user.email"},
+		Rename:  map[string]string{"name": "title"},
+		Convert: map[string]string{"price": "number", "created": "date", "user.id": "integer"},
+		Set:     map[string]interface{}{"env": "test"},
+	}
+	assert.Nil(t, tr.Validate())
+
+	doc, err := tr.Apply(src)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"title":   "foo",
+		"price":   12.5,
+		"created": "2020-01-01T00:00:00Z",
+		"user":    map[string]interface{}{"id": int64(7)},
+		"env":     "test",
+	}, doc)
+
+	// the source document is left untouched
+	assert.Equal(t, "x", src["secret"])
+
+	doc, err = (&Transform{Include: []string{"name", "user.id"}}).Apply(src)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name": "foo",
+		"user": map[string]interface{}{"id": "7"},
+	}, doc)
+
+	_, err = (&Transform{Convert: map[string]string{"name": "number"}}).Apply(src)
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, (&Transform{Convert: map[string]string{"name": "uuid"}}).Validate())
+
+	doc, err = (&Transform{Convert: map[string]string{"created": "epoch_second"}}).Apply(src)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1577836800), doc["created"])
+
+	doc, err = (&Transform{Convert: map[string]string{"created": "epoch_millis"}}).Apply(src)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1577836800000), doc["created"])
+}
+
+func Test_TransformChainedRenames(t *testing.T) {
+	tr := &Transform{Rename: map[string]string{"b": "c", "a": "b"}}
+	for i := 0; i < 20; i++ {
+		doc, err := tr.Apply(map[string]interface{}{"a": 1, "b": 2})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"c": 1}, doc)
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6"
+	"github.com/ll2l/esweb/bookmarks"
+)
+
+// esConfig builds the client configuration of a connection, with its TLS transport
+func esConfig(conf bookmarks.Bookmark) (elasticsearch.Config, error) {
+	cfg := elasticsearch.Config{
+		Addresses: conf.Addresses,
+		CloudID:   conf.CloudID,
+		APIKey:    conf.APIKey,
+	}
+	if conf.CloudID != "" {
+		cfg.Addresses = nil
+	}
+
+	if conf.User != "" && conf.Password != "" {
+		cfg.Username = conf.User
+		cfg.Password = conf.Password
+	}
+
+	tlsConfig, err := tlsConfig(conf)
+	if err != nil {
+		return cfg, err
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	cfg.Transport = transport
+
+	if conf.BearerToken != "" {
+		cfg.Transport = &bearerTransport{token: conf.BearerToken, next: transport}
+	}
+	return cfg, nil
+}
+
+func tlsConfig(conf bookmarks.Bookmark) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: conf.Insecure}
+
+	if conf.CACert != "" {
+		pem, err := readPEM(conf.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("CA certificate: %s", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA certificate: no PEM certificate found")
+		}
+	}
+
+	if conf.ClientCert != "" || conf.ClientKey != "" {
+		if conf.ClientCert == "" || conf.ClientKey == "" {
+			return nil, fmt.Errorf("client certificate and key must be given together")
+		}
+		certPEM, err := readPEM(conf.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %s", err)
+		}
+		keyPEM, err := readPEM(conf.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client key: %s", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %s", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+// IsInlinePEM reports whether a certificate or key option holds PEM data rather than
+// the path of a file
+func IsInlinePEM(val string) bool {
+	return strings.HasPrefix(strings.TrimSpace(val), "-----BEGIN")
+}
+
+// readPEM returns PEM data given inline or as a file path
+func readPEM(val string) ([]byte, error) {
+	if IsInlinePEM(val) {
+		return []byte(val), nil
+	}
+	return ioutil.ReadFile(val)
+}
+
+// bearerTransport authenticates requests with a bearer token
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it is given
+	r := *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(&r)
+}
+
+// describeConnError turns TLS handshake failures into a hint on which connection option to fix
+func describeConnError(err error) error {
+	cause := err
+	if ue, ok := cause.(*url.Error); ok {
+		cause = ue.Err
+	}
+
+	switch e := cause.(type) {
+	case x509.UnknownAuthorityError:
+		return fmt.Errorf("TLS: the server certificate is signed by an unknown authority, set the CA certificate or skip verification: %s", e)
+	case x509.HostnameError:
+		return fmt.Errorf("TLS: the server certificate does not match the host name: %s", e)
+	case x509.CertificateInvalidError:
+		return fmt.Errorf("TLS: the server certificate is not valid: %s", e)
+	case tls.RecordHeaderError:
+		return fmt.Errorf("TLS: the server does not speak TLS, use an http:// address: %s", e)
+	}
+
+	if msg := cause.Error(); strings.Contains(msg, "tls: bad certificate") || strings.Contains(msg, "certificate required") {
+		return fmt.Errorf("TLS: the server rejected the client certificate, set a valid client certificate and key: %s", msg)
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewFromConfigTLS(t *testing.T) {
+	var auth string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"cluster_name":"test","version":{"number":"7.10.2"}}`))
+	}))
+	defer srv.Close()
+
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+	err = cl.Ping()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown authority")
+	}
+
+	cl, err = NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}, CACert: caPEM, BearerToken: "token"})
+	assert.NoError(t, err)
+	assert.NoError(t, cl.Ping())
+	assert.Equal(t, "Bearer token", auth)
+	assert.Equal(t, 7, cl.MajorVersion())
+
+	cl, err = NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}, Insecure: true})
+	assert.NoError(t, err)
+	assert.NoError(t, cl.Ping())
+
+	_, err = NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}, CACert: "-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----"})
+	assert.Error(t, err)
+
+	_, err = NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}, ClientCert: caPEM})
+	assert.Error(t, err)
+}
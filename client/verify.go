@@ -0,0 +1,260 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// Verification modes
+const (
+	VerifySample = "sample" // Compare a random sample of the source documents
+	VerifyFull   = "full"   // Compare every document and look for extra destination documents
+)
+
+// VerifyReport compares the destination index of a migration with its source
+type VerifyReport struct {
+	SrcIndexName string `json:"src_index"`
+	DstIndexName string `json:"dst_index"`
+	Mode         string `json:"mode"`
+	SrcCount     int64  `json:"src_count"`
+	DstCount     int64  `json:"dst_count"`
+	Checked      int64  `json:"checked"`
+	Missing      int64  `json:"missing"`
+	Extra        int64  `json:"extra"`
+	Differing    int64  `json:"differing"`
+	File         string `json:"file,omitempty"` // NDJSON file listing every problem document
+	Took         string `json:"took"`
+}
+
+// VerifyIssue is a document missing from the destination, extra in the destination
+// or whose content differs, one line of the report file
+type VerifyIssue struct {
+	ID      string `json:"_id"`
+	Issue   string `json:"issue"`
+	SrcHash string `json:"src_hash,omitempty"`
+	DstHash string `json:"dst_hash,omitempty"`
+}
+
+// VerifyReportPath returns the path of a verification report file by name
+func VerifyReportPath(name string) (string, error) {
+	return dataFilePath(verifyKind, name)
+}
+
+// Verify checks that the destination holds every source document matching the migration
+// query with the same content, after the transform. In sample mode size random source
+// documents are compared, in full mode every document is.
+func (mc *MigrateConfig) Verify(mode string, size int) (*VerifyReport, error) {
+	start := time.Now()
+	mc.setDefaults()
+
+	if mode != VerifySample && mode != VerifyFull {
+		return nil, fmt.Errorf("unknown verification mode %q", mode)
+	}
+	if mode == VerifySample && size <= 0 {
+		return nil, fmt.Errorf("the sample size must be greater than 0")
+	}
+
+	q, err := mc.SearchQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mc.DstEs.ManageIndex(mc.DstIndexName, "refresh"); err != nil {
+		return nil, fmt.Errorf("cannot refresh %s: %s", mc.DstIndexName, err)
+	}
+
+	report := &VerifyReport{
+		SrcIndexName: mc.SrcIndexName,
+		DstIndexName: mc.DstIndexName,
+		Mode:         mode,
+	}
+
+	if report.SrcCount, err = countDocs(mc.SrcEs, mc.SrcIndexName, q); err != nil {
+		return nil, err
+	}
+	if report.DstCount, err = countDocs(mc.DstEs, mc.DstIndexName, nil); err != nil {
+		return nil, err
+	}
+
+	path := dataFileName(verifyKind, mc.DstIndexName)
+	w := newNDJSONWriter(path)
+	defer w.Close()
+
+	compare := func(r *searchResponse) error {
+		return mc.compareDocs(r, report, w)
+	}
+
+	if mode == VerifySample {
+		r, err := mc.SrcEs.searchBody(mc.SrcIndexName, map[string]interface{}{
+			"size": size,
+			"query": map[string]interface{}{
+				"function_score": map[string]interface{}{
+					"query":        q,
+					"random_score": map[string]interface{}{},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := compare(r); err != nil {
+			return nil, err
+		}
+	} else {
+		err := mc.SrcEs.scroll(mc.SrcIndexName, map[string]interface{}{"query": q}, mc.Size, mc.KeepAlive, compare)
+		if err != nil {
+			return nil, err
+		}
+
+		extra := func(r *searchResponse) error {
+			return mc.findExtraDocs(r, q, report, w)
+		}
+		err = mc.DstEs.scroll(mc.DstIndexName, map[string]interface{}{"_source": false}, mc.Size, mc.KeepAlive, extra)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("cannot write the report: %s", err)
+	}
+	if report.Missing+report.Extra+report.Differing > 0 {
+		report.File = filepath.Base(path)
+	}
+	report.Took = time.Since(start).Truncate(time.Millisecond).String()
+	return report, nil
+}
+
+// compareDocs looks up a page of source documents in the destination
+func (mc *MigrateConfig) compareDocs(r *searchResponse, report *VerifyReport, w *ndjsonWriter) error {
+	if r.IsEmpty() {
+		return nil
+	}
+
+	ids := make([]string, 0, len(r.Hits.Hits))
+	for _, hit := range r.Hits.Hits {
+		ids = append(ids, hit.ID)
+	}
+
+	dst, err := mc.DstEs.searchBody(mc.DstIndexName, map[string]interface{}{
+		"size":  len(ids),
+		"query": map[string]interface{}{"ids": map[string]interface{}{"values": ids}},
+	})
+	if err != nil {
+		return err
+	}
+
+	dstDocs := make(map[string]map[string]interface{}, len(dst.Hits.Hits))
+	for _, hit := range dst.Hits.Hits {
+		dstDocs[hit.ID] = hit.Source
+	}
+
+	for _, hit := range r.Hits.Hits {
+		report.Checked++
+
+		source, err := mc.transformDoc(hit.Type, hit.Source)
+		if err != nil {
+			return fmt.Errorf("transform %s: %s", hit.ID, err)
+		}
+
+		dstSource, ok := dstDocs[hit.ID]
+		if !ok {
+			report.Missing++
+			if err := w.Write(VerifyIssue{ID: hit.ID, Issue: "missing", SrcHash: docHash(source)}); err != nil {
+				return fmt.Errorf("cannot write the report: %s", err)
+			}
+			continue
+		}
+
+		if srcHash, dstHash := docHash(source), docHash(dstSource); srcHash != dstHash {
+			report.Differing++
+			if err := w.Write(VerifyIssue{ID: hit.ID, Issue: "differs", SrcHash: srcHash, DstHash: dstHash}); err != nil {
+				return fmt.Errorf("cannot write the report: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// findExtraDocs looks for destination documents without a matching source document
+func (mc *MigrateConfig) findExtraDocs(r *searchResponse, q map[string]interface{}, report *VerifyReport, w *ndjsonWriter) error {
+	if r.IsEmpty() {
+		return nil
+	}
+
+	ids := make([]string, 0, len(r.Hits.Hits))
+	for _, hit := range r.Hits.Hits {
+		ids = append(ids, hit.ID)
+	}
+
+	src, err := mc.SrcEs.searchBody(mc.SrcIndexName, map[string]interface{}{
+		"size":    len(ids),
+		"_source": false,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					q,
+					map[string]interface{}{"ids": map[string]interface{}{"values": ids}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool, len(src.Hits.Hits))
+	for _, hit := range src.Hits.Hits {
+		found[hit.ID] = true
+	}
+
+	for _, id := range ids {
+		if !found[id] {
+			report.Extra++
+			if err := w.Write(VerifyIssue{ID: id, Issue: "extra"}); err != nil {
+				return fmt.Errorf("cannot write the report: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// docHash returns a content hash of a document, independent of the order of its fields
+func docHash(source map[string]interface{}) string {
+	// encoding/json writes map keys sorted
+	b, _ := json.Marshal(source)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// countDocs returns the number of documents of an index matching a query, all documents when q is nil
+func countDocs(c *Client, index string, q map[string]interface{}) (int64, error) {
+	var body bytes.Buffer
+	if q != nil {
+		if err := json.NewEncoder(&body).Encode(map[string]interface{}{"query": q}); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := c.es.Count(
+		c.es.Count.WithIndex(index),
+		c.es.Count.WithBody(&body),
+	)
+	if err := checkElasticResp(res, err); err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	var r struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, err
+	}
+	return r.Count, nil
+}
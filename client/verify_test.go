@@ -0,0 +1,64 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ll2l/esweb/bookmarks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_docHash(t *testing.T) {
+	a := map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"c": "d", "e": []interface{}{1.0, 2.0}}}
+	b := map[string]interface{}{"b": map[string]interface{}{"e": []interface{}{1.0, 2.0}, "c": "d"}, "a": 1.0}
+	assert.Equal(t, docHash(a), docHash(b))
+
+	// converted integers hash like the numbers read back from the destination
+	assert.Equal(t, docHash(map[string]interface{}{"n": int64(7)}), docHash(map[string]interface{}{"n": 7.0}))
+
+	b["a"] = 2.0
+	assert.NotEqual(t, docHash(a), docHash(b))
+}
+
+func Test_VerifyReportWriteError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+		default:
+			w.Write([]byte(`{"hits":{"total":{"value":0},"hits":[]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewFromConfig(bookmarks.Bookmark{Addresses: []string{srv.URL}})
+	assert.NoError(t, err)
+
+	mc := MigrateConfig{SrcEs: cl, DstEs: cl, SrcIndexName: "logs", DstIndexName: "copy"}
+	_, err = mc.Verify(VerifySample, 0)
+	assert.Error(t, err)
+
+	f, err := ioutil.TempFile("", "esweb-report")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// The report cannot be created below a file
+	w := newNDJSONWriter(filepath.Join(f.Name(), "report.ndjson"))
+	defer w.Close()
+
+	var r searchResponse
+	assert.NoError(t, json.Unmarshal([]byte(`{"hits":{"hits":[{"_id":"1","_source":{"a":1}}]}}`), &r))
+	report := &VerifyReport{}
+	err = mc.compareDocs(&r, report, w)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cannot write the report")
+	}
+	assert.Equal(t, int64(1), report.Missing)
+}
@@ -0,0 +1,263 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Settings which no longer exist from a major version on
+var removedSettings = map[int][]string{
+	7: {
+		"index.mapping.single_type",
+		"index.mapper.dynamic",
+	},
+	8: {
+		"index.frozen",
+		"index.search.throttled",
+		"index.max_adjacency_matrix_filters",
+		"index.force_memory_term_dictionary",
+		"index.merge.policy.max_merge_at_once_explicit",
+	},
+}
+
+// Analysis component types renamed in 7.x, the old names are rejected by 8.x
+var renamedAnalysisTypes = map[string]string{
+	"nGram":     "ngram",
+	"edgeNGram": "edge_ngram",
+}
+
+// DistributionOpenSearch is the version.distribution reported by OpenSearch clusters
+const DistributionOpenSearch = "opensearch"
+
+// Elasticsearch major version each OpenSearch major version behaves like
+var openSearchCompat = map[int]int{
+	1: 7, // forked from 7.10
+	2: 8, // mapping types removed
+}
+
+// MajorVersion returns the Elasticsearch major version the cluster behaves like,
+// 6 when it is unknown
+func (c *Client) MajorVersion() int {
+	if c == nil || c.serverVersion == "" {
+		return 6
+	}
+	major, err := strconv.Atoi(strings.SplitN(c.serverVersion, ".", 2)[0])
+	if err != nil {
+		return 6
+	}
+	if c.IsOpenSearch() {
+		if v, ok := openSearchCompat[major]; ok {
+			return v
+		}
+		return 8
+	}
+	return major
+}
+
+// ServerVersion returns the version number reported by the cluster
+func (c *Client) ServerVersion() string {
+	return c.serverVersion
+}
+
+// Distribution returns the distribution reported by the cluster, "" for Elasticsearch
+func (c *Client) Distribution() string {
+	return c.distribution
+}
+
+// IsOpenSearch reports whether the cluster runs OpenSearch
+func (c *Client) IsOpenSearch() bool {
+	return c != nil && c.distribution == DistributionOpenSearch
+}
+
+// Product returns the name and version of the cluster software
+func (c *Client) Product() string {
+	if c.IsOpenSearch() {
+		return "OpenSearch " + c.serverVersion
+	}
+	return "Elasticsearch " + c.serverVersion
+}
+
+// convertIndexBody translates the mappings and settings read from a cluster of
+// major version from, as returned by indexBody, for a cluster of major version to.
+// When typeField is set the mappings get a keyword field holding the source _type.
+func convertIndexBody(body map[string]interface{}, from, to int, typeField string) map[string]interface{} {
+	mappings, _ := body["mappings"].(map[string]interface{})
+	settings, _ := body["settings"].(map[string]interface{})
+
+	if mappings != nil {
+		typeName := mappingType(mappings)
+		mappings = typelessMappings(mappings)
+
+		if typeField != "" {
+			props, ok := mappings["properties"].(map[string]interface{})
+			if !ok {
+				props = map[string]interface{}{}
+				mappings["properties"] = props
+			}
+			props[typeField] = map[string]interface{}{"type": "keyword"}
+		}
+
+		if to >= 7 {
+			delete(mappings, "_all")
+			removeMappingParam(mappings, "include_in_all")
+		} else {
+			if typeName == "" {
+				typeName = "_doc"
+			}
+			mappings = map[string]interface{}{typeName: mappings}
+		}
+		body["mappings"] = mappings
+	}
+
+	if settings != nil {
+		for v := from + 1; v <= to; v++ {
+			for _, name := range removedSettings[v] {
+				deleteField(settings, name)
+			}
+		}
+
+		if to >= 7 {
+			if analysis, ok := getField(settings, "index.analysis"); ok {
+				convertAnalysis(analysis)
+			}
+		}
+	}
+
+	return body
+}
+
+// typelessMappings returns the mappings without the 6.x type level, the first type
+// wins as a 6.x index has only one
+func typelessMappings(mappings map[string]interface{}) map[string]interface{} {
+	if _, ok := mappings["properties"]; ok {
+		return mappings
+	}
+	for _, m := range mappings {
+		if tm, ok := m.(map[string]interface{}); ok {
+			return tm
+		}
+	}
+	return mappings
+}
+
+// MappingProperties returns the top level fields of typed (6.x) or typeless (7.x+) mappings
+func MappingProperties(mappings interface{}) map[string]interface{} {
+	m, ok := mappings.(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	props, ok := typelessMappings(m)["properties"].(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	return props
+}
+
+// mappingType returns the type name of 6.x typed mappings, "" for typeless ones
+func mappingType(mappings interface{}) string {
+	m, ok := mappings.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if _, ok := m["properties"]; ok {
+		return ""
+	}
+	for name, tm := range m {
+		if _, ok := tm.(map[string]interface{}); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func removeMappingParam(m map[string]interface{}, param string) {
+	props, ok := m["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, p := range props {
+		if f, ok := p.(map[string]interface{}); ok {
+			delete(f, param)
+			removeMappingParam(f, param)
+		}
+	}
+}
+
+// convertAnalysis renames the analysis component types and drops the standard token
+// filter, which 7.x removed
+func convertAnalysis(analysis interface{}) {
+	a, ok := analysis.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, kind := range []string{"filter", "tokenizer"} {
+		components, _ := a[kind].(map[string]interface{})
+		for _, c := range components {
+			if comp, ok := c.(map[string]interface{}); ok {
+				if typ, ok := comp["type"].(string); ok && renamedAnalysisTypes[typ] != "" {
+					comp["type"] = renamedAnalysisTypes[typ]
+				}
+			}
+		}
+	}
+
+	analyzers, _ := a["analyzer"].(map[string]interface{})
+	for _, an := range analyzers {
+		analyzer, ok := an.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		filters, ok := analyzer["filter"].([]interface{})
+		if !ok {
+			continue
+		}
+		kept := make([]interface{}, 0, len(filters))
+		for _, f := range filters {
+			if f == "standard" {
+				continue
+			}
+			if name, ok := f.(string); ok && renamedAnalysisTypes[name] != "" {
+				f = renamedAnalysisTypes[name]
+			}
+			kept = append(kept, f)
+		}
+		analyzer["filter"] = kept
+	}
+}
+
+// transformDoc applies the transform to a source document and keeps its _type in TypeField
+func (mc *MigrateConfig) transformDoc(typ string, src map[string]interface{}) (map[string]interface{}, error) {
+	source, err := mc.Transform.Apply(src)
+	if err != nil || mc.TypeField == "" || typ == "" {
+		return source, err
+	}
+
+	doc := make(map[string]interface{}, len(source)+1)
+	for k, v := range source {
+		doc[k] = v
+	}
+	doc[mc.TypeField] = typ
+	return doc, nil
+}
+
+// setDocType picks the document type used for bulk requests: none for 7.x+
+// destinations, the type of the destination index for 6.x ones
+func (mc *MigrateConfig) setDocType() error {
+	mc.docType = ""
+	if mc.DstEs.MajorVersion() >= 7 {
+		return nil
+	}
+
+	m, err := mc.DstEs.Mapping(mc.DstIndexName)
+	if err != nil {
+		return err
+	}
+	if index, ok := m[mc.DstIndexName].(map[string]interface{}); ok {
+		mc.docType = mappingType(index["mappings"])
+	}
+	if mc.docType == "" {
+		mc.docType = "_doc"
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MajorVersion(t *testing.T) {
+	assert.Equal(t, 6, (&Client{}).MajorVersion())
+	assert.Equal(t, 6, (&Client{serverVersion: "6.8.2"}).MajorVersion())
+	assert.Equal(t, 7, (&Client{serverVersion: "7.10.1"}).MajorVersion())
+	assert.Equal(t, 8, (&Client{serverVersion: "8.11.0"}).MajorVersion())
+	assert.Equal(t, 7, (&Client{serverVersion: "1.3.2", distribution: DistributionOpenSearch}).MajorVersion())
+	assert.Equal(t, 8, (&Client{serverVersion: "2.11.0", distribution: DistributionOpenSearch}).MajorVersion())
+	assert.Equal(t, "OpenSearch 2.11.0", (&Client{serverVersion: "2.11.0", distribution: DistributionOpenSearch}).Product())
+}
+
+func index6Body() map[string]interface{} {
+	return map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"doc": map[string]interface{}{
+				"_all": map[string]interface{}{"enabled": false},
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": "text", "include_in_all": false},
+				},
+			},
+		},
+		"settings": map[string]interface{}{
+			"index": map[string]interface{}{
+				"number_of_shards": "1",
+				"mapping":          map[string]interface{}{"single_type": "true"},
+				"analysis": map[string]interface{}{
+					"filter": map[string]interface{}{
+						"grams": map[string]interface{}{"type": "edgeNGram", "min_gram": 1},
+					},
+					"analyzer": map[string]interface{}{
+						"autocomplete": map[string]interface{}{
+							"tokenizer": "standard",
+							"filter":    []interface{}{"standard", "lowercase", "grams"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func Test_convertIndexBody(t *testing.T) {
+	body := convertIndexBody(index6Body(), 6, 7, "doc_type")
+
+	assert.Equal(t, map[string]interface{}{
+		"properties": map[string]interface{}{
+			"name":     map[string]interface{}{"type": "text"},
+			"doc_type": map[string]interface{}{"type": "keyword"},
+		},
+	}, body["mappings"])
+
+	index := body["settings"].(map[string]interface{})["index"].(map[string]interface{})
+	_, ok := getField(index, "mapping.single_type")
+	assert.False(t, ok)
+	assert.Equal(t, "1", index["number_of_shards"])
+
+	analysis := index["analysis"].(map[string]interface{})
+	assert.Equal(t, "edge_ngram", analysis["filter"].(map[string]interface{})["grams"].(map[string]interface{})["type"])
+	assert.Equal(t, []interface{}{"lowercase", "grams"},
+		analysis["analyzer"].(map[string]interface{})["autocomplete"].(map[string]interface{})["filter"])
+
+	// 6.x to 6.x keeps the type and the settings
+	body = convertIndexBody(index6Body(), 6, 6, "")
+	mappings := body["mappings"].(map[string]interface{})
+	assert.Contains(t, mappings, "doc")
+	assert.Contains(t, mappings["doc"], "_all")
+	assert.Contains(t, body["settings"].(map[string]interface{})["index"], "mapping")
+
+	// 7.x to 6.x gets a _doc type
+	body = convertIndexBody(map[string]interface{}{
+		"mappings": map[string]interface{}{"properties": map[string]interface{}{}},
+	}, 7, 6, "")
+	assert.Contains(t, body["mappings"], "_doc")
+}
+
+func Test_transformDoc(t *testing.T) {
+	mc := &MigrateConfig{TypeField: "doc_type"}
+	src := map[string]interface{}{"name": "a"}
+
+	doc, err := mc.transformDoc("user", src)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "a", "doc_type": "user"}, doc)
+	assert.NotContains(t, src, "doc_type")
+
+	mc.TypeField = ""
+	doc, err = mc.transformDoc("user", src)
+	assert.NoError(t, err)
+	assert.Equal(t, src, doc)
+}
+
+func Test_hitsTotal(t *testing.T) {
+	var r searchResponse
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"hits":{"total":42,"hits":[]}}`), &r))
+	assert.Equal(t, hitsTotal(42), r.Hits.Total)
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"hits":{"total":{"value":10000,"relation":"gte"},"hits":[]}}`), &r))
+	assert.Equal(t, hitsTotal(10000), r.Hits.Total)
+}
+
+func Test_MappingProperties(t *testing.T) {
+	props := map[string]interface{}{"name": map[string]interface{}{"type": "text"}}
+
+	assert.Equal(t, props, MappingProperties(map[string]interface{}{"doc": map[string]interface{}{"properties": props}}))
+	assert.Equal(t, props, MappingProperties(map[string]interface{}{"properties": props}))
+	assert.Empty(t, MappingProperties(map[string]interface{}{}))
+}
@@ -1,19 +1,26 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jessevdk/go-flags"
 
 	"github.com/ll2l/esweb/api"
+	"github.com/ll2l/esweb/audit"
+	"github.com/ll2l/esweb/auth"
 	"github.com/ll2l/esweb/bookmarks"
 	"github.com/ll2l/esweb/client"
 )
@@ -46,7 +53,8 @@ func initBookmarks() {
 	viper.AddConfigPath(bookmarksDir)
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			bookmarks.Clusters["default"] = bookmarks.Bookmark{Addresses: []string{client.DefaultCluster}}
+			bookmarks.File = filepath.Join(bookmarksDir, "bookmark.toml")
+			bookmarks.Clusters["default"] = bookmarks.Bookmark{Addresses: []string{client.DefaultCluster}, Transient: true}
 			log.Printf("bookmark config file not found, using default address %s", client.DefaultCluster)
 			// Config file not found; ignore error if desired
 		} else {
@@ -55,6 +63,10 @@ func initBookmarks() {
 		}
 	}
 
+	if f := viper.ConfigFileUsed(); f != "" && bookmarks.File == "" {
+		bookmarks.File = f
+	}
+
 	all := viper.AllSettings()
 	for name, conf := range all {
 		B := bookmarks.Bookmark{}
@@ -74,8 +86,8 @@ func initClientUsingBookmark(bookmark string) (*client.Client, error) {
 		return nil, err
 	}
 
-	if !cl.Alive() {
-		return nil, fmt.Errorf("cluster:%s is not alive", bookmark)
+	if err := cl.Ping(); err != nil {
+		return nil, fmt.Errorf("cluster:%s is not alive: %s", bookmark, err)
 	}
 
 	if Opts.Debug {
@@ -140,37 +152,129 @@ func initOptions() {
 		os.Exit(0)
 	}
 
+	if options.HashPassword {
+		printPasswordHash()
+		os.Exit(0)
+	}
+
 	if options.ReadOnly {
 		fmt.Println(readonlyWarning)
 	}
 
+	if options.Prefix != "" {
+		api.PathPrefix = "/" + options.Prefix
+	}
+
 	client.DisablePrettyJSON = options.DisablePrettyJSON
+	client.DefaultTimeout = time.Duration(options.QueryTimeout) * time.Second
+	client.CacheTTL = time.Duration(options.CacheTTL) * time.Second
+	client.CacheMaxBytes = int64(options.CacheSize) << 20
+	if options.DataDir != "" {
+		client.DataDir = options.DataDir
+	}
+
+	audit.File = options.AuditFile
+	if audit.File == "" && options.DataDir != "" {
+		audit.File = filepath.Join(options.DataDir, "audit.log")
+	}
+
+	client.SlowQueryThreshold = time.Duration(options.SlowQueryMs) * time.Millisecond
+	client.SlowLogFile = options.SlowLogFile
+	client.SlowLogMaxBytes = int64(options.SlowLogSize) << 20
+	if client.SlowLogFile == "" && options.DataDir != "" {
+		client.SlowLogFile = filepath.Join(options.DataDir, "slow.log")
+	}
 
 	printVersion()
 }
 
+// initUsers loads the users file, a --auth-user account is an admin
+func initUsers() {
+	if options.UsersFile != "" {
+		if err := auth.Load(options.UsersFile); err != nil {
+			exitWithMessage(err.Error())
+		}
+		return
+	}
+
+	// Enable HTTP basic authentication only if both user and password are set
+	if options.AuthUser == "" || options.AuthPass == "" {
+		return
+	}
+	hash, err := auth.HashPassword(options.AuthPass)
+	if err != nil {
+		exitWithMessage(err.Error())
+	}
+	if err := auth.AddUser(&auth.User{Name: options.AuthUser, Password: hash, Roles: []string{auth.RoleAdmin}}); err != nil {
+		exitWithMessage(err.Error())
+	}
+}
+
+// printPasswordHash prints the hash of the first line of stdin
+func printPasswordHash() {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		exitWithMessage(err.Error())
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		exitWithMessage("no password given on stdin")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		exitWithMessage(err.Error())
+	}
+	fmt.Println(hash)
+}
+
 func startServer() {
 	router := gin.Default()
 
-	// Enable HTTP basic authentication only if both user and password are set
-	if options.AuthUser != "" && options.AuthPass != "" {
-		auth := map[string]string{options.AuthUser: options.AuthPass}
-		router.Use(gin.BasicAuth(auth))
+	if auth.Enabled() {
+		router.Use(api.BasicAuth)
 	}
 
 	api.SetupRoutes(router)
 
 	fmt.Println("Starting server...")
-	go func() {
-		err := router.Run(fmt.Sprintf("%v:%v", options.HTTPHost, options.HTTPPort))
-		if err != nil {
-			fmt.Println("Cant start server:", err)
-			if strings.Contains(err.Error(), "address already in use") {
-				openPage()
-			}
-			os.Exit(1)
+	if !options.TLSEnabled() {
+		go serve(&http.Server{Addr: fmt.Sprintf("%v:%v", options.HTTPHost, options.HTTPPort), Handler: router}, false)
+		return
+	}
+
+	tlsConfig, err := serverTLSConfig(options)
+	if err != nil {
+		exitWithMessage(err.Error())
+	}
+
+	go serve(&http.Server{
+		Addr:      fmt.Sprintf("%v:%v", options.HTTPHost, options.HTTPSPort),
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}, true)
+
+	if !options.SSLOnly {
+		go serve(&http.Server{Addr: fmt.Sprintf("%v:%v", options.HTTPHost, options.HTTPPort), Handler: httpsRedirect(options.HTTPSPort)}, false)
+	}
+}
+
+func serve(srv *http.Server, withTLS bool) {
+	var err error
+	if withTLS {
+		// The certificates are in srv.TLSConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+
+	if err != nil {
+		fmt.Println("Cant start server:", err)
+		if strings.Contains(err.Error(), "address already in use") {
+			openPage()
 		}
-	}()
+		os.Exit(1)
+	}
 }
 
 func handleSignals() {
@@ -180,7 +284,14 @@ func handleSignals() {
 }
 
 func openPage() {
-	url := fmt.Sprintf("http://%v:%v/%s", options.HTTPHost, options.HTTPPort, options.Prefix)
+	path := "/"
+	if options.Prefix != "" {
+		path = "/" + options.Prefix + "/"
+	}
+	url := fmt.Sprintf("http://%v:%v%s", options.HTTPHost, options.HTTPPort, path)
+	if options.TLSEnabled() {
+		url = fmt.Sprintf("https://%v:%v%s", options.HTTPHost, options.HTTPSPort, path)
+	}
 	fmt.Println("To view database open", url, "in browser")
 
 	if options.SkipOpen {
@@ -198,6 +309,7 @@ func openPage() {
 func Run() {
 	initOptions()
 	initBookmarks()
+	initUsers()
 	initClient()
 
 	if !options.Debug {
@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"os"
 	"os/user"
+	"strings"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/ll2l/esweb/client"
 )
 
 type Options struct {
@@ -18,6 +21,8 @@ type Options struct {
 	HTTPPort                     uint   `long:"listen" description:"HTTP server listen port" default:"8081"`
 	AuthUser                     string `long:"auth-user" description:"HTTP basic auth user"`
 	AuthPass                     string `long:"auth-pass" description:"HTTP basic auth password"`
+	UsersFile                    string `long:"users-file" description:"File with the esweb users, their password hashes and roles"`
+	HashPassword                 bool   `long:"hash-password" description:"Print the hash of a password read from stdin for the users file"`
 	SkipOpen                     bool   `short:"s" long:"skip-open" description:"Skip browser open on start"`
 	Sessions                     bool   `long:"sessions" description:"Enable multiple database sessions"`
 	ReadOnly                     bool   `long:"readonly" description:"Run database connection in readonly mode"`
@@ -28,10 +33,29 @@ type Options struct {
 	ConnectionIdleTimeout        int    `long:"idle-timeout" description:"Set connection idle timeout in minutes" default:"180"`
 	Prefix                       string `long:"prefix" description:"Add a url prefix"`
 	DisablePrettyJSON            bool   `long:"no-pretty-json" description:"Disable JSON formatting feature for result export"`
+	QueryTimeout                 int    `long:"query-timeout" description:"Default timeout of searches in seconds, sent to Elasticsearch. Queries override it with the timeout parameter, 0 for none" default:"0"`
+	CacheTTL                     int    `long:"cache-ttl" description:"Seconds search results are reused for the same query, 0 disables the result cache" default:"0"`
+	CacheSize                    int    `long:"cache-size" description:"Memory limit of the result cache in MB" default:"64"`
+	SlowQueryMs                  int    `long:"slow-query-ms" description:"Queries taking at least this many milliseconds go to the slow log, 0 disables it" default:"1000"`
+	SlowLogFile                  string `long:"slow-log" description:"JSON lines log of the slow queries. Defaults to slow.log in the data directory"`
+	SlowLogSize                  int    `long:"slow-log-size" description:"Size in MB from which the slow log is moved to <slow-log>.1" default:"16"`
+	AuditFile                    string `long:"audit-file" description:"JSON lines log of the changes made through esweb. Defaults to audit.log in the data directory"`
+	DataDir                      string `long:"data-dir" description:"Directory for esweb files such as migration dead-letter files. Defaults to $HOME/.esweb" default:""`
+	SSLCert                      string `long:"ssl-cert" description:"TLS certificate file to serve HTTPS with"`
+	SSLKey                       string `long:"ssl-key" description:"TLS private key file of the certificate"`
+	SSLSelfSigned                bool   `long:"ssl-self-signed" description:"Serve HTTPS with a generated self-signed certificate, kept in the data directory"`
+	SSLClientCA                  string `long:"ssl-client-ca" description:"Require client certificates signed by the CAs of this file"`
+	HTTPSPort                    uint   `long:"ssl-listen" description:"HTTPS server listen port" default:"8443"`
+	SSLOnly                      bool   `long:"ssl-only" description:"Do not listen for plain HTTP, by default it redirects to HTTPS"`
 }
 
 var Opts Options
 
+// TLSEnabled reports whether the UI is served over HTTPS
+func (opts Options) TLSEnabled() bool {
+	return opts.SSLCert != "" || opts.SSLSelfSigned
+}
+
 // ParseOptions returns a new options struct from the input arguments
 func ParseOptions(args []string) (Options, error) {
 	var opts = Options{}
@@ -44,6 +68,7 @@ func ParseOptions(args []string) (Options, error) {
 	if opts.Prefix == "" {
 		opts.Prefix = os.Getenv("URL_PREFIX")
 	}
+	opts.Prefix = strings.Trim(strings.TrimSpace(opts.Prefix), "/")
 
 	if os.Getenv("SESSIONS") != "" {
 		opts.Sessions = true
@@ -61,6 +86,34 @@ func ParseOptions(args []string) (Options, error) {
 		opts.Pass = ""
 	}
 
+	if opts.DataDir == "" {
+		opts.DataDir = client.DataDir
+	}
+
+	if (opts.SSLCert == "") != (opts.SSLKey == "") {
+		return opts, errors.New("--ssl-cert and --ssl-key must be given together")
+	}
+
+	if opts.SSLCert != "" && opts.SSLSelfSigned {
+		return opts, errors.New("--ssl-self-signed cannot be combined with --ssl-cert")
+	}
+
+	if opts.QueryTimeout < 0 {
+		return opts, errors.New("--query-timeout cannot be negative")
+	}
+
+	if opts.CacheTTL < 0 || opts.CacheSize < 0 {
+		return opts, errors.New("--cache-ttl and --cache-size cannot be negative")
+	}
+
+	if opts.SlowQueryMs < 0 || opts.SlowLogSize < 0 {
+		return opts, errors.New("--slow-query-ms and --slow-log-size cannot be negative")
+	}
+
+	if (opts.SSLClientCA != "" || opts.SSLOnly) && !opts.TLSEnabled() {
+		return opts, errors.New("--ssl-client-ca and --ssl-only need --ssl-cert or --ssl-self-signed")
+	}
+
 	if opts.AuthUser == "" && os.Getenv("AUTH_USER") != "" {
 		opts.AuthUser = os.Getenv("AUTH_USER")
 	}
@@ -69,6 +122,10 @@ func ParseOptions(args []string) (Options, error) {
 		opts.AuthPass = os.Getenv("AUTH_PASS")
 	}
 
+	if opts.UsersFile != "" && (opts.AuthUser != "" || opts.AuthPass != "") {
+		return opts, errors.New("--users-file cannot be combined with --auth-user and --auth-pass")
+	}
+
 	return opts, nil
 }
 
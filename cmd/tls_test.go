@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_serverTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esweb-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := Options{SSLSelfSigned: true, DataDir: dir, HTTPHost: "esweb.local"}
+	c, err := serverTLSConfig(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, c.ClientAuth)
+
+	// The generated certificate is reused
+	before, _ := ioutil.ReadFile(dir + "/tls/selfsigned-cert.pem")
+	_, err = serverTLSConfig(opts)
+	assert.NoError(t, err)
+	after, _ := ioutil.ReadFile(dir + "/tls/selfsigned-cert.pem")
+	assert.Equal(t, before, after)
+
+	opts.SSLClientCA = dir + "/tls/selfsigned-cert.pem"
+	c, err = serverTLSConfig(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, c.ClientAuth)
+}
+
+func Test_httpsRedirect(t *testing.T) {
+	tests := map[uint]string{
+		8443: "https://example.com:8443/api/info?x=1",
+		443:  "https://example.com/api/info?x=1",
+	}
+	for port, want := range tests {
+		w := httptest.NewRecorder()
+		httpsRedirect(port).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com:8081/api/info?x=1", nil))
+		assert.Equal(t, 301, w.Code)
+		assert.Equal(t, want, w.Header().Get("Location"))
+	}
+}
+
+func Test_ParseOptionsTLS(t *testing.T) {
+	_, err := ParseOptions([]string{"--ssl-cert", "cert.pem"})
+	assert.Error(t, err)
+
+	_, err = ParseOptions([]string{"--ssl-only"})
+	assert.Error(t, err)
+
+	opts, err := ParseOptions([]string{"--ssl-self-signed", "--ssl-only"})
+	assert.NoError(t, err)
+	assert.True(t, opts.TLSEnabled())
+}
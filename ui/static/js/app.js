@@ -3,6 +3,7 @@ var mappingEditor       = null;
 var connected           = false;
 var use_kibana          = false;
 var bookmarks           = {};
+var currentUser         = null;
 var default_rows_limit  = 100;
 var currentObject       = null;
 var autocompleteObjects = [];
@@ -38,6 +39,10 @@ function getRowsLimit() {
   return parseInt(localStorage.getItem("rows_limit") || default_rows_limit);
 }
 
+function getSqlEngine() {
+  return localStorage.getItem("sql_engine") || "auto";
+}
+
 function getPaginationOffset() {
   var page  = $(".current-page").data("page");
   var limit = getRowsLimit();
@@ -55,20 +60,25 @@ function getPagesCount(rowsCount) {
   return num;
 }
 
+// Returns the URL of an API path, under the URL prefix esweb is mounted at
+function apiUrl(path) {
+  return (window.urlPrefix || "") + "/api" + path;
+}
+
 function apiCall(method, path, params, cb) {
   var timeout = 300000; // 5 mins is enough
 
   $.ajax({
     timeout: timeout,
-    url: "api" + path,
+    url: apiUrl(path),
     method: method,
     cache: false,
     data: params,
     headers: {
       "x-session-id": getSessionId()
     },
-    success: function(data) {
-      cb(data);
+    success: function(data, status, xhr) {
+      cb(data, xhr);
     },
     error: function(xhr, status, data) {
       if (status == "timeout") {
@@ -89,11 +99,36 @@ function getTableStructure(table, opts, cb) { apiCall("get", "/mapping/" + table
 function getTableIndexes(table, cb)         { apiCall("get", "/tables/" + table + "/indexes", {}, cb); }
 function getTableConstraints(table, cb)     { apiCall("get", "/tables/" + table + "/constraints", {}, cb); }
 function getHistory(cb)                     { apiCall("get", "/history", {}, cb); }
+function getSavedQueries(cb)                { apiCall("get", "/queries", {}, cb); }
+function saveQuery(params, cb)              { apiCall("post", "/queries", params, cb); }
+function runSavedQuery(name, params, cb)    { apiCall("post", "/queries/run/" + encodeURIComponent(name), { params: params }, cb); }
+function getSearchTemplates(cb)             { apiCall("get", "/templates", {}, cb); }
+function templatePath(id, action)           { return "/templates/" + encodeURIComponent(id) + (action ? "/" + action : ""); }
 function getBookmarks(cb)                   { apiCall("get", "/bookmarks", {}, cb); }
-function executeQuery(query, cb)            { apiCall("post", "/query", { query: query }, cb); }
+function createBookmark(params, cb)         { apiCall("post", "/bookmarks", params, cb); }
+function updateBookmark(name, params, cb)   { apiCall("put", "/bookmarks/" + name, params, cb); }
+function deleteBookmark(name, cb)           { apiCall("delete", "/bookmarks/" + name, {}, cb); }
+function testBookmark(params, cb)           { apiCall("post", "/bookmarks/test", params, cb); }
+function cancelQuery(id, cb)                { apiCall("post", "/cancel/" + id, {}, cb); }
 function explainQuery(query, cb)            { apiCall("post", "/explain", { query: query }, cb); }
 function disconnect(cb)                     { apiCall("post", "/disconnect", {}, cb); }
 
+var currentQueryId = null;
+
+// Runs a SQL query with an id which the cancel button stops it by
+function executeQuery(query, cb) {
+  var id = currentQueryId = guid();
+  var params = { query: query, engine: getSqlEngine(), query_id: id, timeout: $.trim($("#query_timeout").val()) };
+  if ($("#bypass_cache").is(":checked")) {
+    params.no_cache = true;
+  }
+
+  apiCall("post", "/query", params, function(data, xhr) {
+    if (currentQueryId == id) currentQueryId = null;
+    cb(data, xhr);
+  });
+}
+
 function encodeQuery(query) {
   return Base64.encode(query).replace(/\+/g, "-").replace(/\//g, "_").replace(/=/g, ".");
 }
@@ -247,10 +282,26 @@ function indexManagement(opt, index) {
   });
 }
 
+// Destructive index actions need a token, issued for the index name typed again
+function confirmIndexAction(index, action, cb) {
+  var typed = prompt("This will " + action + " index " + index + ". Type the index name to confirm:");
+  if (typed === null) return;
+
+  apiCall("post", "/indices/" + index + "/confirm", { action: action, confirm: typed }, function(data) {
+    if (data.error) {
+      alert(data.error);
+      return;
+    }
+    cb(data.token);
+  });
+}
+
 function performTableAction(table, action, el) {
   if (action == "close" || action == "delete" || action == "freeze") {
-    var message = "Are you sure you want to " + action + " index " + table + " ?";
-    if (!confirm(message)) return;
+    confirmIndexAction(table, action, function(token) {
+      indexManagement({ action: action, token: token }, table);
+    });
+    return;
   }
 
   if (action.match(/(freeze|merge|close|clear_cache|flush|delete|refresh|open)/i)) {
@@ -265,12 +316,12 @@ function performTableAction(table, action, el) {
       var db = $("#current_database").text();
       var filename = db + "." + table + "." + format;
       var query = window.encodeURI("SELECT * FROM " + table);
-      var url = window.location.href.split("#")[0] + "api/query?format=" + format + "&filename=" + filename + "&query=" + query + "&_session_id=" + getSessionId();
+      var url = apiUrl("/query?format=" + format + "&filename=" + filename + "&query=" + query + "&_session_id=" + getSessionId());
       var win  = window.open(url, "_blank");
       win.focus();
       break;
     case "dump":
-      var url = window.location.href.split("#")[0] + "api/export?table=" + table + "&_session_id=" + getSessionId();
+      var url = apiUrl("/export?table=" + table + "&_session_id=" + getSessionId());
       var win  = window.open(url, "_blank");
       win.focus();
       break;
@@ -279,6 +330,8 @@ function performTableAction(table, action, el) {
        $('#migrate_modal').modal("show");
        $('#src_index').html(table);
        $('#dst_index').val(table+"_copy");
+       $("#migrate_preview").hide();
+       $("#migrate_replay_button").hide();
       break;
     case "copy":
       copyToClipboard(table);
@@ -305,7 +358,7 @@ function performViewAction(view, action, el) {
       var db = $("#current_database").text();
       var filename = db + "." + view + "." + format;
       var query = window.encodeURI("SELECT * FROM " + view);
-      var url = window.location.href.split("#")[0] + "api/query?format=" + format + "&filename=" + filename + "&query=" + query + "&_session_id=" + getSessionId();
+      var url = apiUrl("/query?format=" + format + "&filename=" + filename + "&query=" + query + "&_session_id=" + getSessionId());
       var win  = window.open(url, "_blank");
       win.focus();
       break;
@@ -316,6 +369,16 @@ function performViewAction(view, action, el) {
 }
 
 function performRowAction(action, value) {
+  if (action == "run_saved_query") {
+    performSavedQuery(value);
+    return;
+  }
+
+  if (action == "edit_template") {
+    openTemplate(value);
+    return;
+  }
+
   if (action == "stop_query") {
     if (!confirm("Are you sure you want to stop the query?")) return;
     executeQuery("SELECT pg_cancel_backend(" + value + ");", function(data) {
@@ -414,6 +477,11 @@ function setCurrentTab(id) {
     $("#body").removeClass("with-pagination");
   }
 
+  // Script results belong to the query tab
+  if (id != "table_query") {
+    $("#result_tabs").hide();
+  }
+
   $("#nav ul li.selected").removeClass("selected");
   $("#" + id).addClass("selected");
 
@@ -440,6 +508,135 @@ function showQueryHistory() {
   });
 }
 
+function showSavedQueries() {
+  var options = {
+    action: {
+      name: "run_saved_query",
+      title: "run",
+      data: "name",
+      style: "primary"
+    }
+  };
+
+  getSavedQueries(function(data) {
+    if (data.error) {
+      buildTable(data);
+      return;
+    }
+
+    var rows = data.map(function(q) {
+      return [q.name, q.description, (q.tags || []).join(", "), q.language, q.params.join(", "), q.text];
+    });
+    buildTable({ columns: ["name", "description", "tags", "language", "params", "text"], rows: rows }, null, null, options);
+
+    setCurrentTab("saved_queries");
+    $("#input").hide();
+    $("#structure").hide();
+    $("#dsl_query").hide();
+    $("#body").prop("class", "full");
+    $("#results").addClass("no-crop");
+  });
+}
+
+// Asks for the parameters of a saved query and shows its results
+function performSavedQuery(name) {
+  getSavedQueries(function(data) {
+    var query = (data.error ? [] : data).filter(function(q) { return q.name == name; })[0];
+    if (!query) return;
+
+    var params = {};
+    for (var i = 0; i < query.params.length; i++) {
+      var value = prompt("Value of " + query.params[i] + ":");
+      if (value === null) return;
+      params[query.params[i]] = value;
+    }
+
+    runSavedQuery(name, params, function(res) {
+      if (query.language == "dsl" && !res.error) {
+        $("#dslContentModal").html(JSON.stringify(res, null, 4));
+        $("#dslModal").modal("show");
+        return;
+      }
+
+      setCurrentTab("table_query");
+      buildTable(res);
+      $("#input").show();
+      $("#body").removeClass("full");
+      $("#results").data("mode", "query");
+    });
+  });
+}
+
+function showSearchTemplates() {
+  var options = {
+    action: {
+      name: "edit_template",
+      title: "open",
+      data: "id",
+      style: "primary"
+    }
+  };
+
+  getSearchTemplates(function(data) {
+    if (data.error) {
+      buildTable(data);
+      return;
+    }
+
+    var rows = data.map(function(t) { return [t.id, t.params.join(", "), t.source]; });
+    buildTable({ columns: ["id", "params", "source"], rows: rows }, null, null, options);
+
+    setCurrentTab("search_templates");
+    $("#input").hide();
+    $("#structure").hide();
+    $("#dsl_query").hide();
+    $("#body").prop("class", "full");
+    $("#results").addClass("no-crop");
+  });
+}
+
+// Opens the search template modal, empty for a new template
+function openTemplate(id) {
+  $("#template_output").hide().text("");
+  $("#template_id").val(id || "");
+  $("#template_source").val("");
+  $("#template_params").val("");
+  $("#template_delete_button").toggle(!!id && can("templates"));
+  $("#template_save_button").toggle(can("templates"));
+  $("#template_modal").modal("show");
+
+  if (!id) return;
+  apiCall("get", templatePath(id), {}, function(data) {
+    if (data.error) {
+      $("#template_output").show().text(data.error);
+      return;
+    }
+    $("#template_source").val(data.source);
+
+    var params = {};
+    data.params.forEach(function(p) { params[p] = ""; });
+    $("#template_params").val(JSON.stringify(params, null, 2));
+  });
+}
+
+function templateRequest() {
+  return { index: $.trim($("#template_index").val()), params: $.trim($("#template_params").val()) };
+}
+
+function saveCurrentQuery() {
+  var text = $.trim(editor.getSelectedText() || editor.getValue());
+  if (text.length == 0) return;
+
+  var name = prompt("Name of the saved query, use {{param}} in the query for parameters:");
+  if (!name) return;
+  var description = prompt("Description (optional):") || "";
+  var tags = prompt("Tags, comma separated (optional):") || "";
+
+  saveQuery({ name: name, description: description, tags: tags, language: "sql", text: text }, function(data) {
+    if (data.error) alert(data.error);
+  });
+}
+
 function showTableIndexes() {
   var name = getCurrentObject().name;
 
@@ -667,6 +864,39 @@ function showQueryPanel() {
   $("#body").prop("class", "")
 }
 
+// Hide the actions the connected cluster does not support
+function applyServerVersion(info) {
+  var major = parseInt((info["version.number"] || "6").split(".")[0], 10);
+  var opensearch = info["version.distribution"] == "opensearch";
+  $("#tables_context_menu a[data-action='freeze']").closest("li").toggle(!opensearch && major < 8 && can("index:freeze"));
+}
+
+// Permissions of the signed in user, everything is allowed without users
+function can(perm) {
+  if (!currentUser) return true;
+  var perms = currentUser.permissions || [];
+  return perms.indexOf("*") >= 0 || perms.indexOf(perm) >= 0;
+}
+
+// Hides the actions the signed in user is not allowed to use
+function applyPermissions() {
+  $("#tables_context_menu a[data-action]").each(function() {
+    var action = $(this).attr("data-action");
+    var perm = null;
+
+    if (action == "migrate") perm = "migrate";
+    else if (action == "dump") perm = "query";
+    else if (action != "copy" && action != "freeze") perm = "index:" + action;
+
+    if (perm) $(this).closest("li").toggle(can(perm));
+    else if (action == "freeze" && !can("index:freeze")) $(this).closest("li").hide();
+  });
+
+  $("#save_bookmark, #delete_bookmark, .bookmark-roles-group, .bookmark-protected-group").toggle(can("bookmarks"));
+  $("#save_query").toggle(can("queries"));
+  $("#test_bookmark").toggle(can("connect"));
+}
+
 function showConnectionPanel() {
   setCurrentTab("table_connection");
 
@@ -749,6 +979,13 @@ function initQueryEditor() {
     codeEditor = new JSONEditor(codeEditor, codeOptions, example);
     jsonEditor = new JSONEditor(jsonEditor, jsonOptions);
 
+    try {
+        codeEditor.aceEditor.setOptions({ enableLiveAutocompletion: true });
+        codeEditor.aceEditor.completers = [dslAutocompleter];
+    } catch (e) {
+        // The ace bundled with the JSON editor may lack the language tools
+    }
+
     jsonBtn.onclick = function () {
         let index = getCurrentObject().name;
         try {
@@ -768,7 +1005,8 @@ function initQueryEditor() {
         apiCall("post", "/query/", {
             index: index,
             editor: "json",
-            query: JSON.stringify(codeContent)
+            query: JSON.stringify(codeContent),
+            no_cache: $("#bypass_cache").is(":checked")
         }, function (data) {
             $("#input").hide();
             resetTable();
@@ -838,8 +1076,15 @@ function runQuery() {
     return;
   }
 
-  executeQuery(query, function(data) {
-    buildTable(data);
+  $("#result_tabs").hide().html("");
+
+  executeQuery(query, function(data, xhr) {
+    if (data.results) {
+      buildResultTabs(data.results);
+    } else {
+      buildTable(data);
+      showCacheAge(xhr);
+    }
 
     $("#run, #explain, #csv, #json, #xml").prop("disabled", false);
     $("#query_progress").hide();
@@ -858,6 +1103,36 @@ function runQuery() {
   });
 }
 
+// Tells next to the row count when a result came from the result cache
+function showCacheAge(xhr) {
+  if (xhr && xhr.getResponseHeader("X-Cache") == "HIT") {
+    $("#result-rows-count").append(", cached " + xhr.getResponseHeader("Age") + "s ago");
+  }
+}
+
+// Shows the results of a script, one tab per statement
+function buildResultTabs(results) {
+  var tabs = $("#result_tabs").html("");
+
+  results.forEach(function(res, i) {
+    var title = "#" + (i + 1) + " " + (res.error ? "error" : res.rows.length + " rows") + ", " +
+      (res.cached ? "cached " + res.cache_age + "s ago" : res.took_ms + " ms");
+    var link = $("<a href='#'/>").text(title).attr("title", res.statement);
+    var tab = $("<li/>").toggleClass("failed", !!res.error).append(link);
+
+    link.on("click", function(e) {
+      e.preventDefault();
+      tabs.find("li").removeClass("active");
+      tab.addClass("active");
+      buildTable(res);
+    });
+    tabs.append(tab);
+  });
+
+  tabs.show();
+  tabs.find("a").first().click();
+}
+
 function showDsl() {
   setCurrentTab("table_query");
   let query = $.trim(editor.getSelectedText() || editor.getValue());
@@ -866,12 +1141,12 @@ function showDsl() {
     $("#run, #show_dsl, #csv, #json, #xml").prop("disabled", false);
     return;
   }
-  apiCall("get", "/dsl", {query: query}, function (data) {
+  apiCall("get", "/dsl", {query: query, engine: getSqlEngine()}, function (data) {
     if (data.error) {
-      alert("get dsl failed.");
+      alert("get dsl failed: " + data.error);
       return
     }
-    $("#dslContentModal").html(JSON.stringify(data, null, 4));
+    $("#dslContentModal").text("// " + data.engine + ", index " + (data.index || "-") + "\n" + JSON.stringify(data.dsl, null, 4));
     $("#dslModal").modal("show");
 
   })
@@ -909,13 +1184,70 @@ function exportTo(format) {
     return;
   }
 
-  var url = window.location.href.split("#")[0] + "api/query?format=" + format + "&query=" + encodeQuery(query) + "&_session_id=" + getSessionId();
+  var url = apiUrl("/query?format=" + format + "&query=" + encodeQuery(query) + "&engine=" + getSqlEngine() +
+    "&timeout=" + encodeURIComponent($.trim($("#query_timeout").val())) + "&_session_id=" + getSessionId());
   var win = window.open(url, '_blank');
 
   setCurrentTab("table_query");
   win.focus();
 }
 
+function getMigrateParams() {
+  return {
+    src_index: $("#src_index").text(),
+    num_items: $("#num_items").val(),
+    dst_index: $("#dst_index").val(),
+    dst_host:  $("#dst_host").val(),
+    dst_user:  $("#dst_user").val(),
+    dst_pass:  encodeURIComponent($("#dst_password").val()),
+    dst_mode:  $("#dst_mode").val(),
+    mappings:  $("#dst_mappings").val(),
+    type_field: $("#migrate_type_field").val(),
+    where:     $("#migrate_where").val(),
+    query:     $("#migrate_query").val(),
+    sort:      $("#migrate_sort").val(),
+    slices:      $("#migrate_slices").val(),
+    scroll_size: $("#migrate_scroll_size").val(),
+    keep_alive:  $("#migrate_keep_alive").val(),
+    batch_size:  $("#migrate_batch_size").val(),
+    workers:     $("#migrate_workers").val(),
+    verify:      $("#migrate_verify").val(),
+    include:   $("#migrate_include").val(),
+    exclude:   $("#migrate_exclude").val(),
+    rename:    $("#migrate_rename").val(),
+    convert:   $("#migrate_convert").val(),
+    set:       $("#migrate_set").val()
+  };
+}
+
+function showVerifyReport(report) {
+  var message = "Verified " + report.checked + " documents of " + report.src_index + " (" + report.mode + "): " +
+    report.missing + " missing, " + report.extra + " extra, " + report.differing + " differing. " +
+    "Source count " + report.src_count + ", destination count " + report.dst_count + ".";
+
+  if (!report.file) {
+    alert(message);
+    return;
+  }
+
+  if (confirm(message + "\n\nDownload the report?")) {
+    var url = apiUrl("/migrate/reports/" + report.file + "?_session_id=" + getSessionId());
+    window.open(url, "_blank").focus();
+  }
+}
+
+function showMigrateStatus() {
+  apiCall("get", "/migrate/status", {}, function(data) {
+    var lines = [];
+    for (key in data) {
+      var st = data[key];
+      lines.push(key + ": " + st.bulked + "/" + st.total + " documents, " +
+        Math.round(st.docs_per_sec) + " docs/sec, batch size " + st.batch_size);
+    }
+    $("#migrate_status").text(lines.join("\n")).show();
+  });
+}
+
 // Fetch all unique values for the selected column in the table
 function showUniqueColumnsValues(table, column, showCounts) {
   var query = 'SELECT DISTINCT "' + column + '" FROM ' + table;
@@ -970,9 +1302,82 @@ function buildTableFilters(name, type) {
   });
 }
 
-var objectAutocompleter = {
+var autocompleteCache = {};
+
+// Fetches the suggestions of the server once per editor, index and key path
+function getAutocomplete(params, callback) {
+  var key = JSON.stringify(params);
+  if (autocompleteCache[key]) {
+    return callback(null, autocompleteCache[key]);
+  }
+
+  apiCall("get", "/autocomplete", params, function(data) {
+    if (!$.isArray(data)) {
+      return callback(null, autocompleteObjects);
+    }
+    autocompleteCache[key] = data;
+    callback(null, data);
+  });
+}
+
+var sqlAutocompleter = {
   getCompletions: function (editor, session, pos, prefix, callback) {
-    callback(null, autocompleteObjects);
+    var from = /\bfrom\s+([^\s,;()]+)/i.exec(editor.getValue());
+    getAutocomplete({ editor: "sql", index: from ? from[1] : "" }, callback);
+  }
+}
+
+// Returns the keys of the objects enclosing the cursor in a JSON text
+function jsonKeyPath(text) {
+  var stack = [], lastKey = null, inString = false, start = 0;
+
+  for (var i = 0; i < text.length; i++) {
+    var ch = text[i];
+    if (inString) {
+      if (ch == "\\") {
+        i++;
+      } else if (ch == '"') {
+        inString = false;
+        var rest = text.substring(i + 1);
+        if (/^\s*:/.test(rest)) {
+          lastKey = text.substring(start, i);
+        }
+      }
+      continue;
+    }
+
+    if (ch == '"') {
+      inString = true;
+      start = i + 1;
+    } else if (ch == "{" || ch == "[") {
+      stack.push({ brace: ch, key: lastKey });
+      lastKey = null;
+    } else if (ch == "}" || ch == "]") {
+      stack.pop();
+      lastKey = null;
+    } else if (ch == ",") {
+      lastKey = null;
+    }
+  }
+
+  // Arrays take the key of their enclosing property, as in "must": [{...}]
+  var path = [];
+  for (var j = 1; j < stack.length; j++) {
+    if (stack[j].key != null) {
+      path.push(stack[j].key);
+    }
+  }
+  return path;
+}
+
+var dslAutocompleter = {
+  getCompletions: function (editor, session, pos, prefix, callback) {
+    var text = session.getTextRange({ start: { row: 0, column: 0 }, end: pos });
+    getAutocomplete({
+      editor: "json",
+      index: getCurrentObject().name,
+      path: JSON.stringify(jsonKeyPath(text))
+    }, callback);
   }
 }
 
@@ -984,7 +1389,7 @@ function initEditor() {
     enableBasicAutocompletion: true,
     enableLiveAutocompletion: true,
   });
-  // editor.completers.push(objectAutocompleter);
+  editor.completers = [sqlAutocompleter];
 
   editor.setFontSize(13);
   editor.setTheme("ace/theme/tomorrow");
@@ -1103,6 +1508,30 @@ function showConnectionSettings() {
   $("#connection_window").show();
 }
 
+function getSecurityParams() {
+  return {
+    cloud_id:     $("#connection_cloud_id").val(),
+    api_key:      $("#connection_api_key").val(),
+    bearer_token: $("#connection_bearer_token").val(),
+    ca_cert:      $("#connection_ca_cert").val(),
+    client_cert:  $("#connection_client_cert").val(),
+    client_key:   $("#connection_client_key").val(),
+    insecure:     $("#connection_insecure").is(":checked")
+  };
+}
+
+function getBookmarkParams() {
+  return $.extend({
+    addresses: $("#pg_host").val(),
+    user:      $("#pg_user").val(),
+    password:  $("#pg_password").val(),
+    alias:     $("#alias").val(),
+    kibana:    $("#kibana_url").val(),
+    roles:     $("#bookmark_roles").val(),
+    protected: $("#bookmark_protected").val()
+  }, getSecurityParams());
+}
+
 function getConnectionString() {
   var url  = $.trim($("#connection_url").val());
   var mode = $(".connection-group-switch button.active").attr("data");
@@ -1211,7 +1640,7 @@ function bindCurrentDatabaseMenu() {
 
       switch(menuItem.data("action")) {
         case "export":
-          var url = window.location.href.split("#")[0] + "api/export?_session_id=" + getSessionId();
+          var url = apiUrl("/export?_session_id=" + getSessionId());
           var win  = window.open(url, "_blank");
           win.focus();
           break;
@@ -1344,6 +1773,66 @@ $(document).ready(function() {
   $("#table_indexes").on("click",     function() { showTableIndexes();     });
   $("#table_constraints").on("click", function() { showTableConstraints(); });
   $("#table_history").on("click",     function() { showQueryHistory();     });
+  $("#saved_queries").on("click",     function() { showSavedQueries();     });
+  $("#search_templates").on("click",  function() { showSearchTemplates();  });
+
+  $("#new_template").on("click", function() {
+    openTemplate("");
+  });
+
+  $("#template_save_button").on("click", function() {
+    var id = $.trim($("#template_id").val());
+    var params = { source: $("#template_source").val() };
+
+    var save = function() {
+      apiCall("put", templatePath(id), params, function(data) {
+        // Another kind of stored script has this id
+        if (data.status == 409 && confirm(data.error + "?")) {
+          params.overwrite = true;
+          return save();
+        }
+        $("#template_output").show().text(data.error ? data.error : "Saved " + id);
+        if (!data.error) $("#template_delete_button").toggle(can("templates"));
+      });
+    };
+    save();
+  });
+
+  $("#template_delete_button").on("click", function() {
+    var id = $.trim($("#template_id").val());
+    if (!confirm("Are you sure you want to delete search template " + id + " ?")) return;
+
+    apiCall("delete", templatePath(id), {}, function(data) {
+      if (data.error) {
+        $("#template_output").show().text(data.error);
+        return;
+      }
+      $("#template_modal").modal("hide");
+      showSearchTemplates();
+    });
+  });
+
+  $("#template_render_button").on("click", function() {
+    apiCall("post", templatePath($.trim($("#template_id").val()), "render"), templateRequest(), function(data) {
+      $("#template_output").show().text(data.error ? data.error : JSON.stringify(data, null, 2));
+    });
+  });
+
+  $("#template_run_button").on("click", function() {
+    apiCall("post", templatePath($.trim($("#template_id").val()), "run"), templateRequest(), function(data) {
+      if (data.error) {
+        $("#template_output").show().text(data.error);
+        return;
+      }
+      $("#template_modal").modal("hide");
+
+      setCurrentTab("table_query");
+      buildTable(data);
+      $("#input").show();
+      $("#body").removeClass("full");
+      $("#results").data("mode", "query");
+    });
+  });
   $("#table_query").on("click",       function() { showQueryPanel();       });
   $("#table_connection").on("click",  function() { showConnectionPanel();  });
   $("#table_activity").on("click",    function() { showActivityPanel();    });
@@ -1354,10 +1843,25 @@ $(document).ready(function() {
     runQuery();
   });
 
+  $("#save_query").on("click", function() {
+    saveCurrentQuery();
+  });
+
   $("#explain").on("click", function() {
     runExplain();
   });
 
+  $("#cancel_query").on("click", function(e) {
+    e.preventDefault();
+    if (currentQueryId) {
+      cancelQuery(currentQueryId, function() {});
+    }
+  });
+
+  $("#sql_engine").val(getSqlEngine()).on("change", function() {
+    localStorage.setItem("sql_engine", $(this).val());
+  });
+
   $("#show_dsl").on("click", function() {
     showDsl();
   });
@@ -1593,15 +2097,88 @@ $(document).ready(function() {
       return;
     }
 
-    // Fill in bookmarked connection settings
+    // Fill in bookmarked connection settings, the password stays on the server
     $("#pg_host").val(item.addresses);
     $("#pg_user").val(item.user);
-    $("#pg_password").val(item.password);
+    $("#pg_password").val("");
     $("#alias").val(item.alias);
     $("#kibana_url").val(item.kibana_url);
+    $("#bookmark_roles").val((item.roles || []).join(", "));
+    $("#bookmark_protected").val((item.protected || []).join(", "));
+    $("#connection_cloud_id").val(item.cloud_id || "");
+    $("#connection_api_key").val("");
+    $("#connection_bearer_token").val("");
+    $("#connection_ca_cert").val(item.ca_cert || "");
+    $("#connection_client_cert").val(item.client_cert || "");
+    $("#connection_client_key").val("");
+    $("#connection_insecure").prop("checked", !!item.insecure);
     $("#connection_ssl").val(item.ssl);
   });
 
+  $("#toggle_connection_security").on("click", function(e) {
+    e.preventDefault();
+    $(".connection-security-group").toggle();
+  });
+
+  $("#test_bookmark").on("click", function(e) {
+    e.preventDefault();
+
+    var params = getBookmarkParams();
+    params.name = $("#connection_bookmarks").val();
+
+    testBookmark(params, function(resp) {
+      if (resp.error) {
+        $("#connection_error").text(resp.error).show();
+        return;
+      }
+      $("#connection_error").hide();
+      alert("Connected to " + resp.cluster_name + " v" + resp["version.number"]);
+    });
+  });
+
+  $("#save_bookmark").on("click", function(e) {
+    e.preventDefault();
+
+    var current = $("#connection_bookmarks").val();
+    var name = prompt("Bookmark name", current || $("#alias").val());
+    if (!name) return;
+
+    var params = getBookmarkParams();
+    var done = function(resp) {
+      if (resp.error) {
+        $("#connection_error").text(resp.error).show();
+        return;
+      }
+      $("#connection_error").hide();
+      showConnectionSettings();
+    };
+
+    if (current && bookmarks[current] && !bookmarks[current].transient) {
+      params.new_name = name;
+      updateBookmark(current, params, done);
+    }
+    else {
+      params.name = name;
+      createBookmark(params, done);
+    }
+  });
+
+  $("#delete_bookmark").on("click", function(e) {
+    e.preventDefault();
+
+    var name = $("#connection_bookmarks").val();
+    if (!name) return;
+    if (!confirm("Are you sure you want to delete bookmark " + name + " ?")) return;
+
+    deleteBookmark(name, function(resp) {
+      if (resp.error) {
+        $("#connection_error").text(resp.error).show();
+        return;
+      }
+      showConnectionSettings();
+    });
+  });
+
   $("#connection_form").on("submit", function(e) {
     e.preventDefault();
 
@@ -1613,6 +2190,8 @@ $(document).ready(function() {
        params["user"] = $("#pg_user").val();
        params["pass"] = encodeURIComponent($("#pg_password").val());
        params["kibana_url"] = $("#kibana_url").val();
+       params["bookmark"] = $("#connection_bookmarks").val();
+       $.extend(params, getSecurityParams());
     // }
 
     $("#connection_error").hide();
@@ -1628,6 +2207,7 @@ $(document).ready(function() {
       else {
         connected = true;
         loadSchemas();
+        applyServerVersion(resp);
 
         $("#connection_window").hide();
         $("#current_database").text(resp.alias);
@@ -1636,33 +2216,103 @@ $(document).ready(function() {
     });
   });
 
+  $("#migrate_preview_button").on("click", function(e) {
+    e.preventDefault();
+
+    apiCall("post", "/migrate/preview", getMigrateParams(), function(resp) {
+      if (resp.error) {
+        alert(resp.error);
+        return
+      }
+
+      $("#migrate_preview").text(JSON.stringify(resp, null, 2)).show();
+    });
+  });
+
   $("#migrate_button").on("click", function(e) {
     e.preventDefault();
 
     let button = $(this).find("button.migrate_button");
-    let params = {};
-    params["src_index"] = $("#src_index").text();
-    params["num_items"] = $("#num_items").val();
-    params["dst_index"] = $("#dst_index").val();
-    params["dst_host"] = $("#dst_host").val();
-    params["dst_user"] = $("#dst_user").val();
-    params["dst_pass"] = encodeURIComponent($("#dst_password").val());
+    let params = getMigrateParams();
+
+    if (params.dst_mode == "overwrite") {
+      params.confirm = prompt("Index " + params.dst_index + " will be deleted if it exists. Type its name to confirm:");
+      if (params.confirm != params.dst_index) return;
+    }
 
     button.prop("disabled", true).text("Please wait...");
+    var statusTimer = setInterval(showMigrateStatus, 5000);
 
     apiCall("post", "/migrate", params, function(resp) {
+      clearInterval(statusTimer);
+      $("#migrate_status").hide();
       $("#migrate_progress").show();
       if (resp.error) {
        alert(resp.error);
        return
       }
 
+      if (resp.verify) {
+        showVerifyReport(resp.verify);
+      }
+      if (resp.verify_error) {
+        alert("Verification failed: " + resp.verify_error);
+      }
+
+      if (resp.stats && resp.stats.failed > 0) {
+        var file = resp.stats.dead_letter.split(/[\\/]/).pop();
+        $("#migrate_replay_button").data("file", file).show();
+        alert(resp.stats.bulked + " documents migrated, " + resp.stats.failed + " failed and were saved to " + file);
+        return
+      }
+      if (resp.stats && resp.stats.skipped > 0) {
+        alert(resp.stats.bulked + " documents migrated, " + resp.stats.skipped + " already in the destination were kept");
+      }
+
       $("#migrate_modal").modal("hide");
       $("#migrate_progress").hide();
 
     });
   });
 
+  $("#migrate_verify_button").on("click", function(e) {
+    e.preventDefault();
+
+    var params = getMigrateParams();
+    params.verify = params.verify || "full";
+
+    apiCall("post", "/migrate/verify", params, function(resp) {
+      if (resp.error) {
+        alert(resp.error);
+        return
+      }
+      showVerifyReport(resp);
+    });
+  });
+
+  $("#migrate_replay_button").on("click", function(e) {
+    e.preventDefault();
+
+    var params = getMigrateParams();
+    params.file = $(this).data("file");
+    // The destination of a replay is never recreated, its failed documents are added to it
+    if (params.dst_mode == "overwrite") {
+      params.dst_mode = "append";
+    }
+
+    apiCall("post", "/migrate/replay", params, function(resp) {
+      if (resp.error) {
+        alert(resp.error);
+        return
+      }
+
+      alert(resp.stats.bulked + " documents replayed, " + resp.stats.failed + " failed again");
+      if (resp.stats.failed == 0) {
+        $("#migrate_replay_button").hide();
+      }
+    });
+  });
+
   $('#settings_tab').on("click", function (e) {
         e.preventDefault();
         var name = getCurrentObject().name;
@@ -1726,6 +2376,13 @@ $(document).ready(function() {
   }
 
 
+  apiCall("get", "/me", {}, function(resp) {
+    if (!resp.error && resp.name) {
+      currentUser = resp;
+    }
+    applyPermissions();
+  });
+
   apiCall("get", "/connection", {}, function(resp) {
     if (resp.error) {
       connected = false;
@@ -1735,6 +2392,7 @@ $(document).ready(function() {
     else {
       connected = true;
       loadSchemas();
+      applyServerVersion(resp);
 
       $("#current_database").text(resp.alias);
       $("#main").show();